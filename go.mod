@@ -1,6 +1,6 @@
 module github.com/chenquan/go-pkg
 
-go 1.13
+go 1.18
 
 require (
 	github.com/panjf2000/ants/v2 v2.5.0
@@ -8,3 +8,9 @@ require (
 	go.uber.org/goleak v1.1.12
 	golang.org/x/sync v0.0.0-20220513210516-0976fa681c29
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+)
@@ -0,0 +1,132 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xbarrier
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sync"
+)
+
+type (
+	// Tagged is a value read by a MultiReadBarrier, tagged with its source.
+	Tagged struct {
+		// Source is the index of the channel the value was read from.
+		Source int
+		Value  interface{}
+	}
+	// MultiReadBarrier is a read barrier fanning in several channels.
+	//
+	// The channels are served round-robin, a busy channel doesn't starve the others.
+	// Concurrent reads are serialized.
+	MultiReadBarrier struct {
+		ctx          context.Context
+		lock         sync.Mutex
+		readChannels []<-chan interface{}
+		// open is the number of channels not closed yet, closed channels are set to nil.
+		open int
+		// next is the index of the channel to try first.
+		next int
+	}
+)
+
+// NewMultiReadBarrier returns a MultiReadBarrier reading from the given channels.
+func NewMultiReadBarrier(ctx context.Context, readChannels ...<-chan interface{}) *MultiReadBarrier {
+	channels := make([]<-chan interface{}, len(readChannels))
+	copy(channels, readChannels)
+	return &MultiReadBarrier{ctx: ctx, readChannels: channels, open: len(channels)}
+}
+
+// Read data from the channels, blocking until a value is available.
+// Read returns io.EOF once all channels are closed, and ctx.Err() if the context is done first.
+func (r *MultiReadBarrier) Read() (val interface{}, err error) {
+	tagged, err := r.ReadTagged()
+	return tagged.Value, err
+}
+
+// ReadTagged is like Read, but tags the value with its source.
+func (r *MultiReadBarrier) ReadTagged() (Tagged, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for {
+		if err := r.ctx.Err(); err != nil {
+			return Tagged{}, err
+		}
+		if r.open == 0 {
+			return Tagged{}, io.EOF
+		}
+
+		source, val, ok := r.poll()
+		if source < 0 {
+			source, val, ok = r.wait()
+		}
+		if source < 0 {
+			return Tagged{}, r.ctx.Err()
+		}
+
+		r.next = (source + 1) % len(r.readChannels)
+		if !ok {
+			r.readChannels[source] = nil
+			r.open--
+			continue
+		}
+		return Tagged{Source: source, Value: val}, nil
+	}
+}
+
+// poll reads from the first ready channel starting at next without blocking,
+// it returns a negative source if no channel is ready.
+func (r *MultiReadBarrier) poll() (source int, val interface{}, ok bool) {
+	for i := range r.readChannels {
+		source = (r.next + i) % len(r.readChannels)
+		if r.readChannels[source] == nil {
+			continue
+		}
+
+		select {
+		case val, ok = <-r.readChannels[source]:
+			return source, val, ok
+		default:
+		}
+	}
+	return -1, nil, false
+}
+
+// wait blocks until a channel is ready or the context is done,
+// it returns a negative source if the context is done.
+func (r *MultiReadBarrier) wait() (source int, val interface{}, ok bool) {
+	cases := make([]reflect.SelectCase, 0, r.open+1)
+	sources := make([]int, 0, r.open)
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.ctx.Done())})
+	for i, channel := range r.readChannels {
+		if channel != nil {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(channel)})
+			sources = append(sources, i)
+		}
+	}
+
+	chosen, value, ok := reflect.Select(cases)
+	if chosen == 0 {
+		return -1, nil, false
+	}
+	if ok {
+		val = value.Interface()
+	}
+	return sources[chosen-1], val, ok
+}
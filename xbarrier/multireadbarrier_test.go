@@ -0,0 +1,82 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xbarrier
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestMultiReadBarrier(t *testing.T) {
+	a := make(chan interface{}, 10)
+	b := make(chan interface{}, 10)
+	reader := NewMultiReadBarrier(context.Background(), a, b)
+	for i := 0; i < 3; i++ {
+		a <- "a"
+	}
+	b <- "b"
+	close(a)
+	close(b)
+
+	var sources []int
+	for {
+		tagged, err := reader.ReadTagged()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		assert.Equal(t, []string{"a", "b"}[tagged.Source], tagged.Value)
+		sources = append(sources, tagged.Source)
+	}
+	// The sources are served round-robin.
+	assert.Equal(t, []int{0, 1, 0, 0}, sources)
+
+	_, err := reader.Read()
+	assert.Equal(t, io.EOF, err)
+	_, err = NewMultiReadBarrier(context.Background()).Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMultiReadBarrier_Blocking(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	a := make(chan interface{})
+	b := make(chan interface{})
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	reader := NewMultiReadBarrier(ctx, a, b)
+
+	go func() {
+		b <- 1
+	}()
+	tagged, err := reader.ReadTagged()
+	assert.NoError(t, err)
+	assert.Equal(t, Tagged{Source: 1, Value: 1}, tagged)
+
+	errs := make(chan error)
+	go func() {
+		_, err := reader.Read()
+		errs <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	cancelFunc()
+	assert.Equal(t, context.Canceled, <-errs)
+}
@@ -16,12 +16,16 @@
 
 package xbarrier
 
-import "context"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 type (
 	// Reader is a read option.
 	Reader interface {
-		Read() (val interface{}, success bool)
+		Read() (val interface{}, err error)
 	}
 	// ReadBarrier is a read barrier.
 	ReadBarrier struct {
@@ -35,12 +39,56 @@ func NewReadBarrier(ctx context.Context, readChannel <-chan interface{}) *ReadBa
 	return &ReadBarrier{ctx: ctx, readChannel: readChannel}
 }
 
-// Read data from the readChannel channel.
-func (r *ReadBarrier) Read() (val interface{}, success bool) {
+// Read data from the readChannel channel, blocking until a value is available.
+// Read returns io.EOF if the channel is closed, and ctx.Err() if the context is done first.
+func (r *ReadBarrier) Read() (val interface{}, err error) {
+	return r.read(nil)
+}
+
+// ReadTimeout is like Read, but returns context.DeadlineExceeded
+// if no value is available within timeout.
+func (r *ReadBarrier) ReadTimeout(timeout time.Duration) (val interface{}, err error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	return r.read(timer.C)
+}
+
+func (r *ReadBarrier) read(timeout <-chan time.Time) (interface{}, error) {
+	// A done context wins over a ready value.
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	select {
 	case <-r.ctx.Done():
-		return nil, false
-	default:
-		return <-r.readChannel, true
+		return nil, r.ctx.Err()
+	case <-timeout:
+		return nil, context.DeadlineExceeded
+	case val, ok := <-r.readChannel:
+		if !ok {
+			return nil, io.EOF
+		}
+		return val, nil
+	}
+}
+
+// ReadBatch reads up to max values, waiting at most wait for them to arrive.
+// ReadBatch returns early with the values read so far when the channel is closed or the context is done.
+func (r *ReadBarrier) ReadBatch(max int, wait time.Duration) []interface{} {
+	if max <= 0 {
+		panic("max should be greater than 0")
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	batch := make([]interface{}, 0, max)
+	for len(batch) < max {
+		val, err := r.read(timer.C)
+		if err != nil {
+			break
+		}
+		batch = append(batch, val)
 	}
+	return batch
 }
@@ -18,8 +18,12 @@ package xbarrier
 
 import (
 	"context"
-	"github.com/stretchr/testify/assert"
+	"io"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
 )
 
 func TestReadBarrier(t *testing.T) {
@@ -34,18 +38,86 @@ func TestReadBarrier(t *testing.T) {
 		}
 	}()
 	for {
-		read, success := reader.Read()
+		read, err := reader.Read()
 		if read == 9 {
 			cancelFunc()
 		}
 		if read != nil {
-			assert.True(t, success)
+			assert.NoError(t, err)
 			assert.LessOrEqual(t, read, 9)
 		}
 
-		if !success {
+		if err != nil {
+			assert.Equal(t, context.Canceled, err)
 			return
 		}
 	}
+}
+
+func TestReadBarrier_Closed(t *testing.T) {
+	c := make(chan interface{}, 1)
+	reader := NewReadBarrier(context.Background(), c)
+	c <- nil
+	close(c)
+
+	read, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Nil(t, read)
+
+	read, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, read)
+}
+
+func TestReadBarrier_CancelWhileBlocked(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	reader := NewReadBarrier(ctx, make(chan interface{}))
+	errs := make(chan error)
+	go func() {
+		_, err := reader.Read()
+		errs <- err
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	cancelFunc()
+	assert.Equal(t, context.Canceled, <-errs)
+}
+
+func TestReadBarrier_ReadTimeout(t *testing.T) {
+	c := make(chan interface{}, 1)
+	reader := NewReadBarrier(context.Background(), c)
+
+	_, err := reader.ReadTimeout(time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	c <- 1
+	read, err := reader.ReadTimeout(time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, read)
+
+	close(c)
+	_, err = reader.ReadTimeout(time.Millisecond)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadBarrier_ReadBatch(t *testing.T) {
+	c := make(chan interface{}, 10)
+	reader := NewReadBarrier(context.Background(), c)
+	for i := 0; i < 5; i++ {
+		c <- i
+	}
+
+	assert.Equal(t, []interface{}{0, 1, 2}, reader.ReadBatch(3, time.Second))
+	assert.Equal(t, []interface{}{3, 4}, reader.ReadBatch(3, time.Millisecond*10))
+	assert.Empty(t, reader.ReadBatch(3, time.Millisecond))
+
+	c <- 5
+	close(c)
+	assert.Equal(t, []interface{}{5}, reader.ReadBatch(3, time.Second))
 
+	assert.Panics(t, func() {
+		reader.ReadBatch(0, time.Second)
+	})
 }
@@ -18,17 +18,33 @@ package xbarrier
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is an error that indicates the channel of a WriteBarrier is closed.
+	ErrClosed = errors.New("write barrier closed")
+	// ErrFull is an error that indicates a value can't be written without blocking.
+	ErrFull = errors.New("write barrier full")
 )
 
 type (
 	// Writer is a write option.
 	Writer interface {
-		Write(v interface{}) (success bool)
+		Write(v interface{}) error
 	}
 	// WriteBarrier is a write barrier.
 	WriteBarrier struct {
 		ctx          context.Context
 		writeChannel chan<- interface{}
+
+		// lock is held for reading while writing and for writing while closing the channel.
+		lock      sync.RWMutex
+		closed    bool
+		done      chan struct{}
+		closeOnce sync.Once
 	}
 )
 
@@ -37,16 +53,80 @@ func NewWriteBarrier(ctx context.Context, writeChannel chan<- interface{}) *Writ
 	return &WriteBarrier{
 		ctx:          ctx,
 		writeChannel: writeChannel,
+		done:         make(chan struct{}),
 	}
 }
 
-// Write the value to the writeChannel channel.
-func (w *WriteBarrier) Write(v interface{}) (success bool) {
+// Write the value to the writeChannel channel, blocking until the value is sent.
+// Write returns ctx.Err() if the context is done first, and ErrClosed if the channel is closed.
+func (w *WriteBarrier) Write(v interface{}) error {
+	return w.write(v, nil, false)
+}
+
+// TryWrite writes the value to the writeChannel channel if it can be done without blocking,
+// otherwise it returns ErrFull.
+func (w *WriteBarrier) TryWrite(v interface{}) error {
+	return w.write(v, nil, true)
+}
+
+// WriteTimeout is like Write, but returns context.DeadlineExceeded
+// if the value can't be sent within timeout.
+func (w *WriteBarrier) WriteTimeout(v interface{}, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	return w.write(v, timer.C, false)
+}
+
+// Close closes the writeChannel channel, pending and later writes return ErrClosed.
+// The channel must be closed by Close rather than directly when the WriteBarrier is shared.
+func (w *WriteBarrier) Close() {
+	w.closeOnce.Do(func() {
+		// Wake up the blocked writers before waiting for them.
+		close(w.done)
+
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		w.closed = true
+		close(w.writeChannel)
+	})
+}
+
+func (w *WriteBarrier) write(v interface{}, timeout <-chan time.Time, try bool) (err error) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.closed {
+		return ErrClosed
+	}
+	// A done context wins over a ready channel.
+	if err = w.ctx.Err(); err != nil {
+		return err
+	}
+
+	defer func() {
+		// The channel was closed without Close.
+		if recover() != nil {
+			err = ErrClosed
+		}
+	}()
+
+	if try {
+		select {
+		case w.writeChannel <- v:
+			return nil
+		default:
+			return ErrFull
+		}
+	}
+
 	select {
 	case <-w.ctx.Done():
-		return
-	default:
-		w.writeChannel <- v
-		return true
+		return w.ctx.Err()
+	case <-w.done:
+		return ErrClosed
+	case <-timeout:
+		return context.DeadlineExceeded
+	case w.writeChannel <- v:
+		return nil
 	}
 }
@@ -18,8 +18,11 @@ package xbarrier
 
 import (
 	"context"
-	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
 )
 
 func TestWriteBarrier(t *testing.T) {
@@ -27,17 +30,17 @@ func TestWriteBarrier(t *testing.T) {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	writer := NewWriteBarrier(ctx, c)
 	go func() {
-		defer close(c)
+		defer writer.Close()
 		for i := 0; i < 11; i++ {
-			success := writer.Write(1)
+			err := writer.Write(1)
 
 			if i == 9 {
 				cancelFunc()
 			}
 			if i > 9 {
-				assert.False(t, success)
+				assert.Equal(t, context.Canceled, err)
 			} else {
-				assert.True(t, success)
+				assert.NoError(t, err)
 			}
 		}
 	}()
@@ -49,3 +52,59 @@ func TestWriteBarrier(t *testing.T) {
 
 	assert.Equal(t, 10, idx)
 }
+
+func TestWriteBarrier_CancelWhileBlocked(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	writer := NewWriteBarrier(ctx, make(chan interface{}))
+	errs := make(chan error)
+	go func() {
+		errs <- writer.Write(1)
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	cancelFunc()
+	assert.Equal(t, context.Canceled, <-errs)
+}
+
+func TestWriteBarrier_TryWrite(t *testing.T) {
+	c := make(chan interface{}, 1)
+	writer := NewWriteBarrier(context.Background(), c)
+
+	assert.NoError(t, writer.TryWrite(1))
+	assert.Equal(t, ErrFull, writer.TryWrite(2))
+	assert.Equal(t, 1, <-c)
+}
+
+func TestWriteBarrier_WriteTimeout(t *testing.T) {
+	c := make(chan interface{}, 1)
+	writer := NewWriteBarrier(context.Background(), c)
+
+	assert.NoError(t, writer.WriteTimeout(1, time.Millisecond))
+	assert.Equal(t, context.DeadlineExceeded, writer.WriteTimeout(2, time.Millisecond))
+	assert.Equal(t, 1, <-c)
+}
+
+func TestWriteBarrier_Closed(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	c := make(chan interface{})
+	writer := NewWriteBarrier(context.Background(), c)
+	errs := make(chan error)
+	go func() {
+		errs <- writer.Write(1)
+	}()
+
+	time.Sleep(time.Millisecond * 10)
+	writer.Close()
+	writer.Close()
+	assert.Equal(t, ErrClosed, <-errs)
+	assert.Equal(t, ErrClosed, writer.Write(1))
+	assert.Equal(t, ErrClosed, writer.TryWrite(1))
+
+	c = make(chan interface{})
+	writer = NewWriteBarrier(context.Background(), c)
+	close(c)
+	assert.Equal(t, ErrClosed, writer.Write(1))
+}
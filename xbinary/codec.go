@@ -0,0 +1,255 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xbinary
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/chenquan/go-pkg/xbytes"
+)
+
+const (
+	bytesTag byte = iota
+	stringTag
+	boolTag
+	intTag
+	uintTag
+	floatTag
+	nilTag
+	uint16Tag
+	uint32Tag
+	// goIntTag is the tag of the int values, kept apart from int64 so that they're read back as int.
+	goIntTag
+)
+
+var (
+	// ErrUnsupportedValue is returned when a Codec cannot encode a value.
+	ErrUnsupportedValue = errors.New("unsupported value type")
+)
+
+type (
+	// Codec writes values and reads them back.
+	Codec interface {
+		// Encode writes value to w.
+		Encode(w io.Writer, value interface{}) error
+		// Decode reads a value from r.
+		Decode(r io.Reader) (interface{}, error)
+	}
+
+	// BinaryCodec is a Codec writing every value after a tag of its type.
+	// It supports nil and values of type []byte, string, bool, uint16, uint32, int, int64, uint64 and float64,
+	// which are read back with the same type.
+	BinaryCodec struct{}
+
+	fullReader struct {
+		r io.Reader
+	}
+)
+
+// NewFullReader returns a reader whose every Read fills the buffer, the readers of this package expect it
+// from readers which may return short reads, like files or bufio.Reader.
+func NewFullReader(r io.Reader) io.Reader {
+	if _, ok := r.(fullReader); ok {
+		return r
+	}
+	return fullReader{r: r}
+}
+
+// Encode writes value to w.
+func (BinaryCodec) Encode(w io.Writer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return WriteTag(w, nilTag)
+	case []byte:
+		return writeTagged(w, bytesTag, func() error {
+			return writeLongBytes(w, v)
+		})
+	case string:
+		return writeTagged(w, stringTag, func() error {
+			return writeLongBytes(w, []byte(v))
+		})
+	case bool:
+		return writeTagged(w, boolTag, func() error {
+			return WriteBool(w, v)
+		})
+	case uint16:
+		return writeTagged(w, uint16Tag, func() error {
+			return WriteUint16(w, v)
+		})
+	case uint32:
+		return writeTagged(w, uint32Tag, func() error {
+			return WriteUint32(w, v)
+		})
+	case int:
+		return writeTagged(w, goIntTag, func() error {
+			return writeUint64(w, uint64(v))
+		})
+	case int64:
+		return writeTagged(w, intTag, func() error {
+			return writeUint64(w, uint64(v))
+		})
+	case uint64:
+		return writeTagged(w, uintTag, func() error {
+			return writeUint64(w, v)
+		})
+	case float64:
+		return writeTagged(w, floatTag, func() error {
+			return writeUint64(w, math.Float64bits(v))
+		})
+	default:
+		return ErrUnsupportedValue
+	}
+}
+
+// Decode reads a value from r.
+func (BinaryCodec) Decode(r io.Reader) (interface{}, error) {
+	r = NewFullReader(r)
+	tag, err := ReadTag(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case nilTag:
+		return nil, nil
+	case bytesTag:
+		return readLongBytes(r)
+	case stringTag:
+		b, err := readLongBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case boolTag:
+		return ReadBool(r)
+	case uint16Tag:
+		return ReadUint16(r)
+	case uint32Tag:
+		return ReadUint32(r)
+	case goIntTag:
+		v, err := readUint64(r)
+		return int(v), err
+	case intTag:
+		v, err := readUint64(r)
+		return int64(v), err
+	case uintTag:
+		return readUint64(r)
+	case floatTag:
+		v, err := readUint64(r)
+		return math.Float64frombits(v), err
+	default:
+		return nil, ErrUnsupportedValue
+	}
+}
+
+// WriteTag writes the single byte tag.
+func WriteTag(w io.Writer, tag byte) error {
+	_, err := w.Write([]byte{tag})
+	return err
+}
+
+// ReadTag reads a tag written by WriteTag.
+func ReadTag(r io.Reader) (byte, error) {
+	tag := make([]byte, 1)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return 0, err
+	}
+	return tag[0], nil
+}
+
+// WriteString writes s prefixed by its uint16 length.
+func WriteString(w io.Writer, s string) error {
+	if len(s) > math.MaxUint16 {
+		return ErrInvalidLength
+	}
+
+	return WriteBytes(w, []byte(s))
+}
+
+// ReadString reads a string written by WriteString.
+func ReadString(r io.Reader) (string, error) {
+	b, err := ReadBytes(NewFullReader(r))
+	if err != nil {
+		return "", err
+	}
+
+	// The payload of ReadBytes comes from a pool, copy it out.
+	s := string(b)
+	if b != nil {
+		xbytes.Free(b)
+	}
+	return s, nil
+}
+
+func (r fullReader) Read(p []byte) (int, error) {
+	return io.ReadFull(r.r, p)
+}
+
+func writeTagged(w io.Writer, tag byte, write func() error) error {
+	if err := WriteTag(w, tag); err != nil {
+		return err
+	}
+
+	return write()
+}
+
+func writeUint64(w io.Writer, v uint64) error {
+	data := xbytes.MallocSize(8)
+	binary.BigEndian.PutUint64(data, v)
+	_, err := w.Write(data)
+	xbytes.Free(data)
+	return err
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	data := xbytes.MallocSize(8)
+	defer xbytes.Free(data)
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// writeLongBytes writes b prefixed by its uint32 length, values may be longer than WriteBytes allows.
+func writeLongBytes(w io.Writer, b []byte) error {
+	if uint64(len(b)) > math.MaxUint32 {
+		return ErrInvalidLength
+	}
+	if err := WriteUint32(w, uint32(len(b))); err != nil {
+		return err
+	}
+
+	_, err := w.Write(b)
+	return err
+}
+
+func readLongBytes(r io.Reader) ([]byte, error) {
+	length, err := ReadUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, length)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,95 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xbinary
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryCodec(t *testing.T) {
+	values := []interface{}{
+		nil,
+		[]byte("bytes"),
+		"string",
+		strings.Repeat("long", 1<<15),
+		true,
+		uint16(16),
+		uint32(32),
+		int64(-1),
+		-1,
+		uint64(1 << 63),
+		1.5,
+	}
+
+	var buf bytes.Buffer
+	codec := BinaryCodec{}
+	for _, value := range values {
+		assert.NoError(t, codec.Encode(&buf, value))
+	}
+	assert.NoError(t, codec.Encode(&buf, 42))
+
+	// Short reads must not break the decoding.
+	r := iotest.OneByteReader(&buf)
+	for _, value := range values {
+		decoded, err := codec.Decode(r)
+		assert.NoError(t, err)
+		assert.Equal(t, value, decoded)
+	}
+	decoded, err := codec.Decode(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, decoded)
+
+	_, err = codec.Decode(r)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Equal(t, ErrUnsupportedValue, codec.Encode(&buf, struct{}{}))
+	_, err = codec.Decode(bytes.NewReader([]byte{255}))
+	assert.Equal(t, ErrUnsupportedValue, err)
+}
+
+func TestTag(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteTag(&buf, 7))
+	tag, err := ReadTag(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(7), tag)
+	_, err = ReadTag(&buf)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestString(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteString(&buf, ""))
+	assert.NoError(t, WriteString(&buf, "key"))
+	assert.Equal(t, ErrInvalidLength, WriteString(&buf, strings.Repeat("k", 1<<16)))
+
+	r := iotest.OneByteReader(&buf)
+	s, err := ReadString(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "", s)
+	s, err = ReadString(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", s)
+	_, err = ReadString(r)
+	assert.Equal(t, io.EOF, err)
+}
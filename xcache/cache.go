@@ -0,0 +1,376 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/chenquan/go-pkg/xsync"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// ReasonExpired means the element outlived its TTL.
+	ReasonExpired EvictReason = iota + 1
+	// ReasonCapacity means the element was evicted to make room for another one.
+	ReasonCapacity
+	// ReasonDeleted means the element was deleted by Delete.
+	ReasonDeleted
+)
+
+type (
+	// EvictReason describes why an element was removed from a Cache.
+	EvictReason int
+	// EvictFunc is called after an element was removed from a Cache.
+	EvictFunc func(key string, value interface{}, reason EvictReason)
+
+	// Cache is a concurrent cache with per-element TTL and an optional capacity.
+	//
+	// The elements are stored in a xsync.SharedMap, reads of a Cache without capacity
+	// don't take any lock of the Cache. Writes, and reads of a Cache with capacity,
+	// are serialized to keep the eviction policy in sync with the elements.
+	//
+	// Expired elements are removed lazily on access, and in the background if
+	// WithTimingWheel is used.
+	Cache struct {
+		entries      *xsync.SharedMap
+		singleFlight singleflight.Group
+		ttl          time.Duration
+		onEvict      EvictFunc
+
+		lock     sync.Mutex
+		size     int
+		capacity int
+		policy   policy
+		wheel    *timingWheel
+
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+
+	// Option customizes a Cache.
+	Option func(*options)
+
+	options struct {
+		ttl            time.Duration
+		capacity       int
+		evictionPolicy EvictionPolicy
+		onEvict        EvictFunc
+		wheelInterval  time.Duration
+		wheelSlots     int
+	}
+
+	entry struct {
+		key      string
+		value    interface{}
+		expireAt int64 // UnixNano, 0 if the element doesn't expire.
+
+		// The fields below are guarded by the lock of the Cache.
+		removed bool
+		// Used by lruPolicy.
+		element *list.Element
+		// Used by lfuPolicy.
+		frequency uint64
+		lastUse   uint64
+		index     int
+		// Used by timingWheel.
+		slot   int
+		rounds int
+	}
+
+	eviction struct {
+		entry  *entry
+		reason EvictReason
+	}
+)
+
+// WithTTL returns an Option that sets the TTL used by Set and GetOrLoad.
+// Elements don't expire by default.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithCapacity returns an Option that limits the number of elements,
+// the elements chosen by the EvictionPolicy are evicted once the capacity is exceeded.
+func WithCapacity(capacity int) Option {
+	return func(o *options) {
+		o.capacity = capacity
+	}
+}
+
+// WithEvictionPolicy returns an Option that sets the EvictionPolicy used with WithCapacity,
+// defaults to LRU.
+func WithEvictionPolicy(evictionPolicy EvictionPolicy) Option {
+	return func(o *options) {
+		o.evictionPolicy = evictionPolicy
+	}
+}
+
+// WithOnEvict returns an Option that sets the function called after an element was removed,
+// except when it's replaced by Set.
+// onEvict is called synchronously by the goroutine which removed the element.
+func WithOnEvict(onEvict EvictFunc) Option {
+	return func(o *options) {
+		o.onEvict = onEvict
+	}
+}
+
+// WithTimingWheel returns an Option that removes the expired elements in the background,
+// with a timing wheel of the given number of slots advancing every interval.
+// An element is removed by the first tick after it expired, the wheel catches up
+// with the elapsed time when the ticks are delayed under load.
+// The Cache must be closed to stop the background goroutine.
+func WithTimingWheel(interval time.Duration, slots int) Option {
+	return func(o *options) {
+		o.wheelInterval = interval
+		o.wheelSlots = slots
+	}
+}
+
+func loadOptions(opts ...Option) *options {
+	o := &options{evictionPolicy: LRU}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// NewCache returns a Cache.
+func NewCache(opts ...Option) *Cache {
+	o := loadOptions(opts...)
+	if o.capacity < 0 {
+		panic("capacity should not be negative")
+	}
+
+	c := &Cache{
+		entries:  xsync.NewSharedMap(),
+		ttl:      o.ttl,
+		onEvict:  o.onEvict,
+		capacity: o.capacity,
+	}
+	if o.capacity > 0 {
+		c.policy = newPolicy(o.evictionPolicy)
+	}
+	if o.wheelInterval != 0 || o.wheelSlots != 0 {
+		c.wheel = newTimingWheel(o.wheelInterval, o.wheelSlots, time.Now().UnixNano())
+		c.done = make(chan struct{})
+		go c.run(o.wheelInterval)
+	}
+	return c
+}
+
+// Get returns the value stored in the Cache for a key.
+// The ok result indicates whether an unexpired value was found.
+func (c *Cache) Get(key string) (value interface{}, ok bool) {
+	v, ok := c.entries.Load(key)
+	if !ok {
+		return nil, false
+	}
+
+	e := v.(*entry)
+	if e.expired(time.Now().UnixNano()) {
+		c.expire(e)
+		return nil, false
+	}
+
+	if c.policy != nil {
+		c.lock.Lock()
+		if !e.removed {
+			c.policy.touch(e)
+		}
+		c.lock.Unlock()
+	}
+	return e.value, true
+}
+
+// GetOrLoad returns the value stored in the Cache for a key,
+// or loads, stores and returns it if absent.
+// Concurrent calls for the same key load the value only once.
+func (c *Cache) GetOrLoad(key string, load func(key string) (interface{}, error)) (interface{}, error) {
+	if value, ok := c.Get(key); ok {
+		return value, nil
+	}
+
+	value, err, _ := c.singleFlight.Do(key, func() (interface{}, error) {
+		if value, ok := c.Get(key); ok {
+			return value, nil
+		}
+
+		value, err := load(key)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Set(key, value)
+		return value, nil
+	})
+	return value, err
+}
+
+// Set stores the value for a key with the TTL of the Cache.
+func (c *Cache) Set(key string, value interface{}) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores the value for a key which expires after ttl,
+// it doesn't expire if ttl is not positive.
+func (c *Cache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	now := time.Now().UnixNano()
+	e := &entry{key: key, value: value}
+	if ttl > 0 {
+		e.expireAt = now + int64(ttl)
+	}
+
+	var evictions []eviction
+	c.lock.Lock()
+	if v, ok := c.entries.Load(key); ok {
+		old := v.(*entry)
+		c.removeLocked(old)
+		if old.expired(now) {
+			evictions = append(evictions, eviction{entry: old, reason: ReasonExpired})
+		} else {
+			e.frequency = old.frequency
+		}
+	}
+
+	// Make room before adding e, which must not be its own victim.
+	for c.capacity > 0 && c.size >= c.capacity {
+		victim := c.policy.victim()
+		c.removeLocked(victim)
+		evictions = append(evictions, eviction{entry: victim, reason: ReasonCapacity})
+	}
+
+	c.entries.Store(key, e)
+	c.size++
+	if c.policy != nil {
+		c.policy.add(e)
+	}
+	if c.wheel != nil && e.expireAt != 0 {
+		c.wheel.add(e)
+	}
+	c.lock.Unlock()
+
+	c.notify(evictions)
+}
+
+// Delete deletes the value for a key and reports whether it was present.
+func (c *Cache) Delete(key string) bool {
+	c.lock.Lock()
+	v, ok := c.entries.Load(key)
+	if !ok {
+		c.lock.Unlock()
+		return false
+	}
+
+	e := v.(*entry)
+	c.removeLocked(e)
+	c.lock.Unlock()
+
+	c.notify([]eviction{{entry: e, reason: ReasonDeleted}})
+	return true
+}
+
+// Len returns the number of elements in the Cache,
+// including the expired elements which are not removed yet.
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.size
+}
+
+// Close stops the background expiry of WithTimingWheel.
+// The Cache can still be used after Close, the expired elements are removed lazily.
+func (c *Cache) Close() {
+	if c.done == nil {
+		return
+	}
+
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *Cache) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.advance()
+		}
+	}
+}
+
+func (c *Cache) advance() {
+	c.lock.Lock()
+	expired := c.wheel.advance(time.Now().UnixNano())
+	evictions := make([]eviction, len(expired))
+	for i, e := range expired {
+		c.removeLocked(e)
+		evictions[i] = eviction{entry: e, reason: ReasonExpired}
+	}
+	c.lock.Unlock()
+
+	c.notify(evictions)
+}
+
+// expire removes e if it's still in the Cache.
+func (c *Cache) expire(e *entry) {
+	c.lock.Lock()
+	if e.removed {
+		c.lock.Unlock()
+		return
+	}
+	c.removeLocked(e)
+	c.lock.Unlock()
+
+	c.notify([]eviction{{entry: e, reason: ReasonExpired}})
+}
+
+func (c *Cache) removeLocked(e *entry) {
+	c.entries.Delete(e.key)
+	c.size--
+	e.removed = true
+
+	if c.policy != nil {
+		c.policy.remove(e)
+	}
+	if c.wheel != nil && e.expireAt != 0 {
+		c.wheel.remove(e)
+	}
+}
+
+func (c *Cache) notify(evictions []eviction) {
+	if c.onEvict == nil {
+		return
+	}
+
+	for _, ev := range evictions {
+		c.onEvict(ev.entry.key, ev.entry.value, ev.reason)
+	}
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expireAt != 0 && e.expireAt <= now
+}
@@ -0,0 +1,218 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xcache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+type evicted struct {
+	key    string
+	value  interface{}
+	reason EvictReason
+}
+
+func recordEvictions() (*[]evicted, *sync.Mutex, Option) {
+	var (
+		lock      sync.Mutex
+		evictions []evicted
+	)
+	return &evictions, &lock, WithOnEvict(func(key string, value interface{}, reason EvictReason) {
+		lock.Lock()
+		evictions = append(evictions, evicted{key: key, value: value, reason: reason})
+		lock.Unlock()
+	})
+}
+
+func TestCache(t *testing.T) {
+	evictions, _, onEvict := recordEvictions()
+	cache := NewCache(onEvict)
+
+	_, ok := cache.Get("a")
+	assert.False(t, ok)
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Set("a", 3)
+	value, _ = cache.Get("a")
+	assert.Equal(t, 3, value)
+	assert.Equal(t, 2, cache.Len())
+
+	assert.True(t, cache.Delete("a"))
+	assert.False(t, cache.Delete("a"))
+	assert.Equal(t, 1, cache.Len())
+	assert.Equal(t, []evicted{{key: "a", value: 3, reason: ReasonDeleted}}, *evictions)
+}
+
+func TestCache_TTL(t *testing.T) {
+	evictions, _, onEvict := recordEvictions()
+	cache := NewCache(WithTTL(time.Millisecond*10), onEvict)
+
+	cache.Set("a", 1)
+	cache.SetWithTTL("b", 2, 0)
+	cache.SetWithTTL("c", 3, time.Hour)
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	time.Sleep(time.Millisecond * 20)
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+	_, ok = cache.Get("b")
+	assert.True(t, ok)
+	_, ok = cache.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, cache.Len())
+	assert.Equal(t, []evicted{{key: "a", value: 1, reason: ReasonExpired}}, *evictions)
+}
+
+func TestCache_TimingWheel(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	evictions, lock, onEvict := recordEvictions()
+	cache := NewCache(WithTimingWheel(time.Millisecond, 4), onEvict)
+	defer cache.Close()
+
+	for i := 0; i < 10; i++ {
+		cache.SetWithTTL(strconv.Itoa(i), i, time.Millisecond*time.Duration(i+1))
+	}
+	cache.Set("forever", true)
+
+	assert.Eventually(t, func() bool {
+		return cache.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, *evictions, 10)
+	for _, e := range *evictions {
+		assert.Equal(t, ReasonExpired, e.reason)
+	}
+}
+
+func TestCache_LRU(t *testing.T) {
+	evictions, _, onEvict := recordEvictions()
+	cache := NewCache(WithCapacity(2), onEvict)
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Get("a")
+	cache.Set("c", 3)
+
+	assert.Equal(t, 2, cache.Len())
+	_, ok := cache.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, []evicted{{key: "b", value: 2, reason: ReasonCapacity}}, *evictions)
+
+	cache.Set("a", 4)
+	cache.Set("d", 5)
+	_, ok = cache.Get("c")
+	assert.False(t, ok)
+	value, _ := cache.Get("a")
+	assert.Equal(t, 4, value)
+}
+
+func TestCache_LFU(t *testing.T) {
+	evictions, _, onEvict := recordEvictions()
+	cache := NewCache(WithCapacity(2), WithEvictionPolicy(LFU), onEvict)
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Get("a")
+	cache.Get("a")
+	cache.Get("b")
+	cache.Set("c", 3)
+
+	// b is used as often as c, but less recently.
+	_, ok := cache.Get("b")
+	assert.False(t, ok)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+	_, ok = cache.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, []evicted{{key: "b", value: 2, reason: ReasonCapacity}}, *evictions)
+
+	assert.Panics(t, func() {
+		NewCache(WithCapacity(1), WithEvictionPolicy(0))
+	})
+}
+
+func TestCache_GetOrLoad(t *testing.T) {
+	cache := NewCache()
+
+	var (
+		loads int32
+		wait  sync.WaitGroup
+	)
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			value, err := cache.GetOrLoad("key", func(key string) (interface{}, error) {
+				atomic.AddInt32(&loads, 1)
+				time.Sleep(time.Millisecond * 10)
+				return key + "-value", nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, "key-value", value)
+		}()
+	}
+	wait.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	_, err := cache.GetOrLoad("fail", func(key string) (interface{}, error) {
+		return nil, errors.New("fail")
+	})
+	assert.EqualError(t, err, "fail")
+	_, ok := cache.Get("fail")
+	assert.False(t, ok)
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	cache := NewCache(WithCapacity(100), WithTTL(time.Millisecond))
+
+	var wait sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wait.Add(1)
+		go func(g int) {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i % 200)
+				cache.Set(key, i)
+				cache.Get(key)
+				if i%3 == g {
+					cache.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wait.Wait()
+	assert.LessOrEqual(t, cache.Len(), 100)
+}
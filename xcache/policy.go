@@ -0,0 +1,146 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xcache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+const (
+	// LRU evicts the least recently used element.
+	LRU EvictionPolicy = iota + 1
+	// LFU evicts the least frequently used element,
+	// the least recently used one among elements used equally often.
+	LFU
+)
+
+type (
+	// EvictionPolicy decides which element is evicted when the Cache is full.
+	EvictionPolicy int
+
+	// policy tracks the usage of the elements, it's guarded by the lock of the Cache.
+	policy interface {
+		add(e *entry)
+		touch(e *entry)
+		remove(e *entry)
+		// victim returns the element to evict, or nil if there is none.
+		victim() *entry
+	}
+
+	lruPolicy struct {
+		list *list.List
+	}
+
+	lfuPolicy struct {
+		heap lfuHeap
+		tick uint64
+	}
+
+	// lfuHeap orders the elements by frequency, then by last use.
+	lfuHeap []*entry
+)
+
+func newPolicy(evictionPolicy EvictionPolicy) policy {
+	switch evictionPolicy {
+	case LRU:
+		return &lruPolicy{list: list.New()}
+	case LFU:
+		return &lfuPolicy{}
+	default:
+		panic("unknown eviction policy")
+	}
+}
+
+func (p *lruPolicy) add(e *entry) {
+	e.element = p.list.PushFront(e)
+}
+
+func (p *lruPolicy) touch(e *entry) {
+	p.list.MoveToFront(e.element)
+}
+
+func (p *lruPolicy) remove(e *entry) {
+	p.list.Remove(e.element)
+	e.element = nil
+}
+
+func (p *lruPolicy) victim() *entry {
+	back := p.list.Back()
+	if back == nil {
+		return nil
+	}
+	return back.Value.(*entry)
+}
+
+func (p *lfuPolicy) add(e *entry) {
+	p.tick++
+	e.frequency++
+	e.lastUse = p.tick
+	heap.Push(&p.heap, e)
+}
+
+func (p *lfuPolicy) touch(e *entry) {
+	p.tick++
+	e.frequency++
+	e.lastUse = p.tick
+	heap.Fix(&p.heap, e.index)
+}
+
+func (p *lfuPolicy) remove(e *entry) {
+	heap.Remove(&p.heap, e.index)
+}
+
+func (p *lfuPolicy) victim() *entry {
+	if len(p.heap) == 0 {
+		return nil
+	}
+	return p.heap[0]
+}
+
+func (h lfuHeap) Len() int {
+	return len(h)
+}
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].frequency != h[j].frequency {
+		return h[i].frequency < h[j].frequency
+	}
+	return h[i].lastUse < h[j].lastUse
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
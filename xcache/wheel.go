@@ -0,0 +1,105 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xcache
+
+import "time"
+
+// timingWheel schedules the expiry of the elements, it's guarded by the lock of the Cache.
+//
+// Every slot covers interval, an element expiring more than a full turn ahead
+// waits for the given number of rounds in its slot.
+// The wheel advances by the elapsed time, so late or dropped ticks are caught up.
+type timingWheel struct {
+	interval time.Duration
+	slots    []map[*entry]struct{}
+	position int
+	// tick is the time the wheel reached position.
+	tick int64
+}
+
+func newTimingWheel(interval time.Duration, slots int, now int64) *timingWheel {
+	if interval <= 0 {
+		panic("interval should be greater than 0")
+	}
+	if slots < 1 {
+		panic("slots should be greater than 0")
+	}
+
+	wheel := &timingWheel{
+		interval: interval,
+		slots:    make([]map[*entry]struct{}, slots),
+		tick:     now,
+	}
+	for i := range wheel.slots {
+		wheel.slots[i] = make(map[*entry]struct{})
+	}
+	return wheel
+}
+
+// add schedules e, which must expire, in the first slot reached after its expiry.
+func (w *timingWheel) add(e *entry) {
+	ticks := int((e.expireAt - w.tick + int64(w.interval) - 1) / int64(w.interval))
+	if ticks < 1 {
+		ticks = 1
+	}
+
+	e.slot = (w.position + ticks) % len(w.slots)
+	e.rounds = (ticks - 1) / len(w.slots)
+	w.slots[e.slot][e] = struct{}{}
+}
+
+func (w *timingWheel) remove(e *entry) {
+	delete(w.slots[e.slot], e)
+}
+
+// advance moves the wheel forward by the intervals elapsed until now
+// and returns the elements expired meanwhile.
+func (w *timingWheel) advance(now int64) []*entry {
+	var expired []*entry
+	for w.tick+int64(w.interval) <= now {
+		w.tick += int64(w.interval)
+		expired = w.step(expired)
+	}
+	return expired
+}
+
+// step moves the wheel one slot forward and appends the expired elements to expired.
+func (w *timingWheel) step(expired []*entry) []*entry {
+	w.position = (w.position + 1) % len(w.slots)
+	slot := w.slots[w.position]
+
+	var late []*entry
+	for e := range slot {
+		if e.rounds > 0 {
+			e.rounds--
+			continue
+		}
+
+		delete(slot, e)
+		if e.expireAt <= w.tick {
+			expired = append(expired, e)
+		} else {
+			late = append(late, e)
+		}
+	}
+
+	// Scheduled again after the loop, they may go back into slot.
+	for _, e := range late {
+		w.add(e)
+	}
+	return expired
+}
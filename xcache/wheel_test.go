@@ -0,0 +1,91 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimingWheel(t *testing.T) {
+	now := time.Now().UnixNano()
+	wheel := newTimingWheel(time.Second, 4, now)
+	soon := &entry{expireAt: now + int64(time.Second)}
+	later := &entry{expireAt: now + int64(time.Second*6)}
+	wheel.add(soon)
+	wheel.add(later)
+	assert.Equal(t, 0, soon.rounds)
+	assert.Equal(t, 1, later.rounds)
+
+	for i := 1; i <= 6; i++ {
+		expired := wheel.advance(now + int64(time.Second)*int64(i))
+		switch i {
+		case 1:
+			assert.Equal(t, []*entry{soon}, expired)
+		case 6:
+			assert.Equal(t, []*entry{later}, expired)
+		default:
+			assert.Empty(t, expired)
+		}
+	}
+
+	assert.Panics(t, func() {
+		newTimingWheel(0, 1, 0)
+	})
+	assert.Panics(t, func() {
+		newTimingWheel(time.Second, 0, 0)
+	})
+}
+
+func TestTimingWheel_Elapsed(t *testing.T) {
+	now := time.Now().UnixNano()
+	wheel := newTimingWheel(time.Second, 4, now)
+	e := &entry{expireAt: now + int64(time.Second)}
+	wheel.add(e)
+
+	// The wheel advanced earlier than expected.
+	assert.Empty(t, wheel.advance(now))
+	assert.Equal(t, []*entry{e}, wheel.advance(now+int64(time.Second)))
+
+	// Dropped ticks are caught up.
+	now += int64(time.Second)
+	var entries []*entry
+	for i := 1; i <= 9; i++ {
+		e := &entry{expireAt: now + int64(time.Second)*int64(i)}
+		wheel.add(e)
+		entries = append(entries, e)
+	}
+	assert.ElementsMatch(t, entries[:6], wheel.advance(now+int64(time.Second*6)))
+	assert.ElementsMatch(t, entries[6:], wheel.advance(now+int64(time.Second*9)))
+}
+
+func TestTimingWheel_LateInSameSlot(t *testing.T) {
+	now := time.Now().UnixNano()
+	wheel := newTimingWheel(time.Second, 4, now)
+	e := &entry{expireAt: now + int64(time.Second)}
+	wheel.add(e)
+
+	// The slot is reached before the expiry, e is scheduled a full turn later into the same slot.
+	e.expireAt = now + int64(time.Second*5)
+	assert.Empty(t, wheel.advance(now+int64(time.Second)))
+	assert.Equal(t, 1, e.slot)
+	assert.Contains(t, wheel.slots[1], e)
+	assert.Empty(t, wheel.advance(now+int64(time.Second*4)))
+	assert.Equal(t, []*entry{e}, wheel.advance(now+int64(time.Second*5)))
+}
@@ -17,6 +17,8 @@
 package xerror
 
 import (
+	"errors"
+
 	"github.com/chenquan/go-pkg/xstring"
 )
 
@@ -62,3 +64,25 @@ func (ea errorArray) Error() string {
 
 	return joiner.String()
 }
+
+// Is reports whether any of the inside errors matches target, see errors.Is.
+func (ea errorArray) Is(target error) bool {
+	for _, err := range ea {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first inside error that matches target, see errors.As.
+func (ea errorArray) As(target interface{}) bool {
+	for _, err := range ea {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
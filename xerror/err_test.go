@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
@@ -61,3 +62,19 @@ func TestBatchErrorWithErrors(t *testing.T) {
 	assert.Equal(t, fmt.Sprintf("%s\n%s", err1, err2), batch.Err().Error())
 	assert.True(t, batch.NotNil())
 }
+
+func TestBatchErrorIsAs(t *testing.T) {
+	var batch BatchError
+	target := errors.New(err2)
+	batch.Add(errors.New(err1))
+	batch.Add(fmt.Errorf("wrapped: %w", target))
+	assert.ErrorIs(t, batch.Err(), target)
+
+	var pathErr *os.PathError
+	assert.False(t, errors.As(batch.Err(), &pathErr))
+	batch.Add(&os.PathError{Op: "open", Path: "file", Err: os.ErrNotExist})
+	if assert.True(t, errors.As(batch.Err(), &pathErr)) {
+		assert.Equal(t, "file", pathErr.Path)
+	}
+	assert.ErrorIs(t, batch.Err(), os.ErrNotExist)
+}
@@ -0,0 +1,35 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xmapreduce
+
+import (
+	"github.com/chenquan/go-pkg/xbinary"
+)
+
+var (
+	// ErrUnsupportedValue is returned when an Encoder cannot encode a value.
+	ErrUnsupportedValue = xbinary.ErrUnsupportedValue
+)
+
+type (
+	// Encoder is used to write the intermediate values of a keyed aggregation to
+	// a spill file and read them back.
+	Encoder = xbinary.Codec
+
+	// BinaryEncoder is the default Encoder, see xbinary.BinaryCodec.
+	BinaryEncoder = xbinary.BinaryCodec
+)
@@ -0,0 +1,255 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xmapreduce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/chenquan/go-pkg/xbarrier"
+)
+
+var (
+	// ErrNoSource is returned by Sink when no source was given to the pipeline.
+	ErrNoSource = errors.New("pipeline has no source")
+)
+
+type (
+	// StageFunc is used to process an item of a stage and write the output to writer.
+	// Returning a non-nil error cancels the whole pipeline.
+	StageFunc func(item interface{}, writer xbarrier.Writer) error
+
+	// GenerateCtxFunc is used to let callers send elements into source until ctx is done.
+	GenerateCtxFunc func(ctx context.Context, source chan<- interface{})
+
+	// SinkFunc is used to consume the output of the last stage.
+	// Returning a non-nil error cancels the whole pipeline.
+	SinkFunc func(item interface{}) error
+
+	// StageError is an error that occurred in a named stage.
+	StageError struct {
+		Stage string
+		Err   error
+	}
+
+	// PipelineBuilder builds a multi-stage pipeline.
+	// Every stage runs with its own concurrency, stages are connected by bounded queues,
+	// and all stages share the same cancellation.
+	PipelineBuilder struct {
+		ctx          context.Context
+		generateFunc GenerateCtxFunc
+		stages       []stage
+		option       *pipelineOptions
+	}
+
+	pipelineOptions struct {
+		queueSize int
+	}
+
+	// PipelineOption defines the method to customize a pipeline.
+	PipelineOption func(opts *pipelineOptions)
+
+	stage struct {
+		name       string
+		stageFunc  StageFunc
+		workerSize int
+	}
+
+	pipelineRunner struct {
+		ctx     context.Context
+		cancel  context.CancelFunc
+		errOnce sync.Once
+		err     error
+		// waitGroup tracks the workers of all stages.
+		waitGroup sync.WaitGroup
+	}
+)
+
+// Error returns the string representation of the StageError.
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %s: %v", e.Stage, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// WithQueueSize customizes the size of the queues between the stages of a pipeline.
+// Defaults to the worker size of the stage writing into the queue.
+func WithQueueSize(queueSize int) PipelineOption {
+	return func(opts *pipelineOptions) {
+		opts.queueSize = queueSize
+	}
+}
+
+// Pipeline returns a PipelineBuilder.
+func Pipeline(ctx context.Context, opts ...PipelineOption) *PipelineBuilder {
+	option := &pipelineOptions{}
+	for _, opt := range opts {
+		opt(option)
+	}
+
+	return &PipelineBuilder{ctx: ctx, option: option}
+}
+
+// Source sets the generate func that sends elements into the pipeline.
+//
+// Once the pipeline is cancelled, the elements still sent by generateFunc are discarded
+// in the background until it returns, use SourceCtx for an unbounded source.
+func (p *PipelineBuilder) Source(generateFunc GenerateFunc) *PipelineBuilder {
+	p.generateFunc = func(ctx context.Context, source chan<- interface{}) {
+		elements := buildSource(generateFunc)
+		for element := range elements {
+			select {
+			case source <- element:
+			case <-ctx.Done():
+				// Let generateFunc finish.
+				go drain(elements)
+				return
+			}
+		}
+	}
+	return p
+}
+
+// SourceCtx sets the generate func that sends elements into the pipeline,
+// ctx is done once the pipeline is cancelled, and generateFunc must return then.
+func (p *PipelineBuilder) SourceCtx(generateFunc GenerateCtxFunc) *PipelineBuilder {
+	p.generateFunc = generateFunc
+	return p
+}
+
+// Stage appends a stage named name which processes items with stageFunc in workerSize goroutines.
+func (p *PipelineBuilder) Stage(name string, stageFunc StageFunc, workerSize int) *PipelineBuilder {
+	if workerSize < 1 {
+		panic("workerSize should be greater than 0")
+	}
+
+	p.stages = append(p.stages, stage{name: name, stageFunc: stageFunc, workerSize: workerSize})
+	return p
+}
+
+// Sink runs the pipeline, feeds the output of the last stage into sinkFunc and
+// blocks until all stages have finished.
+// It returns the first error occurred in a stage or the sink, or the error of ctx if it was done.
+// Once an error occurred or ctx is done, the source and all stages stop.
+func (p *PipelineBuilder) Sink(sinkFunc SinkFunc) error {
+	if p.generateFunc == nil {
+		return ErrNoSource
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+	runner := &pipelineRunner{ctx: ctx, cancel: cancel}
+
+	var pipe <-chan interface{} = runner.runSource(p.generateFunc)
+	for _, s := range p.stages {
+		pipe = runner.runStage(s, pipe, p.queueSize(s))
+	}
+
+	runner.consume(pipe, func(item interface{}) {
+		if err := sinkFunc(item); err != nil {
+			runner.fail(&StageError{Stage: "sink", Err: err})
+		}
+	})
+	cancel()
+	runner.waitGroup.Wait()
+
+	if runner.err != nil {
+		return runner.err
+	}
+
+	return p.ctx.Err()
+}
+
+func (p *PipelineBuilder) queueSize(s stage) int {
+	if p.option.queueSize > 0 {
+		return p.option.queueSize
+	}
+
+	return s.workerSize
+}
+
+func (r *pipelineRunner) runSource(generateFunc GenerateCtxFunc) <-chan interface{} {
+	source := make(chan interface{})
+	r.waitGroup.Add(1)
+	go func() {
+		defer r.waitGroup.Done()
+		defer close(source)
+		generateFunc(r.ctx, source)
+	}()
+
+	return source
+}
+
+func (r *pipelineRunner) runStage(s stage, input <-chan interface{}, queueSize int) <-chan interface{} {
+	output := make(chan interface{}, queueSize)
+	writer := xbarrier.NewWriteBarrier(r.ctx, output)
+
+	var waitGroup sync.WaitGroup
+	waitGroup.Add(s.workerSize)
+	r.waitGroup.Add(s.workerSize)
+	for i := 0; i < s.workerSize; i++ {
+		go func() {
+			defer r.waitGroup.Done()
+			defer waitGroup.Done()
+
+			r.consume(input, func(item interface{}) {
+				if err := s.stageFunc(item, writer); err != nil {
+					r.fail(&StageError{Stage: s.name, Err: err})
+				}
+			})
+		}()
+	}
+
+	go func() {
+		waitGroup.Wait()
+		writer.Close()
+	}()
+
+	return output
+}
+
+// consume calls fn with the items of input until input is closed or the pipeline is cancelled.
+func (r *pipelineRunner) consume(input <-chan interface{}, fn func(item interface{})) {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case item, ok := <-input:
+			if !ok || r.ctx.Err() != nil {
+				return
+			}
+			fn(item)
+		}
+	}
+}
+
+func drain(elements <-chan interface{}) {
+	for range elements {
+	}
+}
+
+func (r *pipelineRunner) fail(err error) {
+	r.errOnce.Do(func() {
+		r.err = err
+		r.cancel()
+	})
+}
@@ -0,0 +1,180 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xmapreduce
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chenquan/go-pkg/xbarrier"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func generateInts(n int) GenerateFunc {
+	return func(source chan<- interface{}) {
+		for i := 0; i < n; i++ {
+			source <- i
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	t.Run("normal", func(t *testing.T) {
+		sum := 0
+		err := Pipeline(context.Background()).
+			Source(generateInts(100)).
+			Stage("format", func(item interface{}, writer xbarrier.Writer) error {
+				writer.Write(strconv.Itoa(item.(int)))
+				return nil
+			}, 4).
+			Stage("parse", func(item interface{}, writer xbarrier.Writer) error {
+				i, err := strconv.Atoi(item.(string))
+				if err != nil {
+					return err
+				}
+				writer.Write(i)
+				return nil
+			}, 16).
+			Sink(func(item interface{}) error {
+				sum += item.(int)
+				return nil
+			})
+		assert.NoError(t, err)
+		assert.Equal(t, 4950, sum)
+	})
+
+	t.Run("no stage", func(t *testing.T) {
+		count := 0
+		err := Pipeline(context.Background(), WithQueueSize(1)).
+			Source(generateInts(10)).
+			Sink(func(item interface{}) error {
+				count++
+				return nil
+			})
+		assert.NoError(t, err)
+		assert.Equal(t, 10, count)
+	})
+
+	t.Run("no source", func(t *testing.T) {
+		err := Pipeline(context.Background()).Sink(func(item interface{}) error {
+			return nil
+		})
+		assert.Equal(t, ErrNoSource, err)
+	})
+
+	t.Run("stage error", func(t *testing.T) {
+		errDummy := errors.New("dummy")
+		err := Pipeline(context.Background()).
+			Source(generateInts(1000)).
+			Stage("fail", func(item interface{}, writer xbarrier.Writer) error {
+				if item.(int) == 10 {
+					return errDummy
+				}
+				writer.Write(item)
+				return nil
+			}, 2).
+			Sink(func(item interface{}) error {
+				return nil
+			})
+
+		assert.ErrorIs(t, err, errDummy)
+		var stageErr *StageError
+		if assert.True(t, errors.As(err, &stageErr)) {
+			assert.Equal(t, "fail", stageErr.Stage)
+		}
+	})
+
+	t.Run("sink error", func(t *testing.T) {
+		errDummy := errors.New("dummy")
+		err := Pipeline(context.Background()).
+			Source(generateInts(1000)).
+			Stage("forward", func(item interface{}, writer xbarrier.Writer) error {
+				writer.Write(item)
+				return nil
+			}, 1).
+			Sink(func(item interface{}) error {
+				return errDummy
+			})
+
+		assert.ErrorIs(t, err, errDummy)
+		assert.EqualError(t, err, "stage sink: dummy")
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		err := Pipeline(ctx).
+			Source(generateInts(1000)).
+			Stage("forward", func(item interface{}, writer xbarrier.Writer) error {
+				writer.Write(item)
+				return nil
+			}, 2).
+			Sink(func(item interface{}) error {
+				cancelFunc()
+				return nil
+			})
+
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("unbounded source", func(t *testing.T) {
+		errDummy := errors.New("dummy")
+		done := make(chan error)
+		go func() {
+			done <- Pipeline(context.Background()).
+				SourceCtx(func(ctx context.Context, source chan<- interface{}) {
+					for i := 0; ; i++ {
+						select {
+						case <-ctx.Done():
+							return
+						case source <- i:
+						}
+					}
+				}).
+				Stage("fail", func(item interface{}, writer xbarrier.Writer) error {
+					if item.(int) == 10 {
+						return errDummy
+					}
+					return writer.Write(item)
+				}, 2).
+				Stage("forward", func(item interface{}, writer xbarrier.Writer) error {
+					return writer.Write(item)
+				}, 2).
+				Sink(func(item interface{}) error {
+					return nil
+				})
+		}()
+
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, errDummy)
+		case <-time.After(time.Second):
+			assert.Fail(t, "the pipeline didn't stop")
+		}
+	})
+}
+
+func TestPipelineStageWorkerSize(t *testing.T) {
+	assert.Panics(t, func() {
+		Pipeline(context.Background()).Stage("any", nil, 0)
+	})
+}
@@ -0,0 +1,444 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xmapreduce
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/chenquan/go-pkg/xbarrier"
+	"github.com/chenquan/go-pkg/xbinary"
+	"github.com/chenquan/go-pkg/xerror"
+)
+
+const defaultPartitions = 16
+
+type (
+	// EmitFunc is used to emit a keyed value.
+	EmitFunc func(key string, value interface{})
+
+	// KeyedMapFunc is used to do element processing and emit keyed values.
+	KeyedMapFunc func(item interface{}, emit EmitFunc)
+
+	// KeyedReduceFunc is used to reduce all the values emitted under the same key.
+	// Returning a non-nil error stops the processing.
+	KeyedReduceFunc func(key string, values []interface{}) error
+
+	keyValue struct {
+		key   string
+		value interface{}
+	}
+
+	// shuffle groups keyed values into partitions,
+	// partitions are spilled to disk when the memory limit is reached.
+	shuffle struct {
+		partitions []*partition
+		option     *options
+		dir        string
+		buffered   int
+	}
+
+	partition struct {
+		values map[string][]interface{}
+		size   int
+		file   *os.File
+		writer *bufio.Writer
+		// runs are the sections of file written by every spill, each sorted by key.
+		runs []spillRun
+		// offset is the size of file.
+		offset int64
+	}
+
+	spillRun struct {
+		offset int64
+		length int64
+	}
+
+	// runCursor iterates the keys of a sorted run with their values.
+	runCursor struct {
+		// index orders the cursors of the same key, the spilled values come first.
+		index  int
+		key    string
+		values []interface{}
+		next   func() (key string, values []interface{}, err error)
+	}
+
+	// cursorHeap is a min-heap of runCursor ordered by key and index.
+	cursorHeap []*runCursor
+
+	// countingWriter counts the bytes written to w.
+	countingWriter struct {
+		w io.Writer
+		n int64
+	}
+)
+
+// WithPartitions customizes a keyed mapreduce processing with given partitions.
+func WithPartitions(partitions int) Option {
+	return func(opts *options) {
+		opts.partitions = partitions
+	}
+}
+
+// WithMemoryLimit customizes the maximum number of keyed values a keyed mapreduce processing
+// holds in memory, partitions are spilled to disk once it's reached.
+// Defaults to 0, which means never spill.
+func WithMemoryLimit(limit int) Option {
+	return func(opts *options) {
+		opts.memoryLimit = limit
+	}
+}
+
+// WithSpillDir customizes the directory where spilled partitions are written to.
+// Defaults to a temporary directory which is removed when the processing finished.
+func WithSpillDir(dir string) Option {
+	return func(opts *options) {
+		opts.spillDir = dir
+	}
+}
+
+// WithEncoder customizes the Encoder used to spill values to disk. Defaults to BinaryEncoder.
+func WithEncoder(encoder Encoder) Option {
+	return func(opts *options) {
+		opts.encoder = encoder
+	}
+}
+
+// MapReduceByKey maps all elements generated from given generate func into keyed values,
+// groups them by key and calls reduceFunc once per key, one partition at a time.
+// With WithMemoryLimit, partitions exceeding the limit are spilled to disk sorted by key and merged back
+// key by key in the reduce phase, only the values of the key being reduced are loaded at once,
+// so the processing is not bound to the available memory.
+func MapReduceByKey(ctx context.Context, generateFunc GenerateFunc, mapFunc KeyedMapFunc,
+	reduceFunc KeyedReduceFunc, opts ...Option) (err error) {
+	option := loadOption(opts...)
+	if option.partitions <= 0 {
+		option.partitions = defaultPartitions
+	}
+	if option.encoder == nil {
+		option.encoder = BinaryEncoder{}
+	}
+
+	s := newShuffle(option)
+	defer func() {
+		if closeErr := s.close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	mapCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	pairs := Map(mapCtx, generateFunc, func(item interface{}, writer xbarrier.Writer) {
+		mapFunc(item, func(key string, value interface{}) {
+			writer.Write(keyValue{key: key, value: value})
+		})
+	}, opts...)
+
+	for pair := range pairs {
+		if err != nil {
+			// Keep draining so that the mappers can exit.
+			continue
+		}
+		if err = s.add(pair.(keyValue)); err != nil {
+			cancel()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.reduce(ctx, reduceFunc)
+}
+
+func newShuffle(option *options) *shuffle {
+	partitions := make([]*partition, option.partitions)
+	for i := range partitions {
+		partitions[i] = &partition{values: map[string][]interface{}{}}
+	}
+
+	return &shuffle{partitions: partitions, option: option}
+}
+
+func (s *shuffle) add(pair keyValue) error {
+	p := s.partitions[s.partitionOf(pair.key)]
+	p.values[pair.key] = append(p.values[pair.key], pair.value)
+	p.size++
+	s.buffered++
+
+	if s.option.memoryLimit <= 0 || s.buffered < s.option.memoryLimit {
+		return nil
+	}
+
+	return s.spill(s.largestPartition())
+}
+
+func (s *shuffle) partitionOf(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(s.partitions)))
+}
+
+func (s *shuffle) largestPartition() *partition {
+	largest := s.partitions[0]
+	for _, p := range s.partitions[1:] {
+		if p.size > largest.size {
+			largest = p
+		}
+	}
+
+	return largest
+}
+
+// spill writes the values of p to a new run of its file, grouped and sorted by key.
+func (s *shuffle) spill(p *partition) error {
+	if p.file == nil {
+		dir, err := s.spillDir()
+		if err != nil {
+			return err
+		}
+
+		file, err := ioutil.TempFile(dir, "partition-")
+		if err != nil {
+			return err
+		}
+		p.file = file
+		p.writer = bufio.NewWriter(file)
+	}
+
+	counter := &countingWriter{w: p.writer}
+	for _, key := range sortedKeys(p.values) {
+		values := p.values[key]
+		if err := xbinary.WriteString(counter, key); err != nil {
+			return err
+		}
+		if err := xbinary.WriteUint32(counter, uint32(len(values))); err != nil {
+			return err
+		}
+		for _, value := range values {
+			if err := s.option.encoder.Encode(counter, value); err != nil {
+				return err
+			}
+		}
+	}
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+	p.runs = append(p.runs, spillRun{offset: p.offset, length: counter.n})
+	p.offset += counter.n
+
+	s.buffered -= p.size
+	p.size = 0
+	p.values = map[string][]interface{}{}
+	return nil
+}
+
+func (s *shuffle) spillDir() (string, error) {
+	if s.dir != "" {
+		return s.dir, nil
+	}
+
+	dir, err := ioutil.TempDir(s.option.spillDir, "xmapreduce-")
+	if err != nil {
+		return "", err
+	}
+	s.dir = dir
+	return dir, nil
+}
+
+// reduce merges the spilled runs and the values in memory of every partition,
+// so only the values of a single key are loaded at a time.
+func (s *shuffle) reduce(ctx context.Context, reduceFunc KeyedReduceFunc) error {
+	for _, p := range s.partitions {
+		if err := s.reducePartition(ctx, p, reduceFunc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *shuffle) reducePartition(ctx context.Context, p *partition, reduceFunc KeyedReduceFunc) error {
+	cursors := make(cursorHeap, 0, len(p.runs)+1)
+	for i, run := range p.runs {
+		r := xbinary.NewFullReader(bufio.NewReader(io.NewSectionReader(p.file, run.offset, run.length)))
+		cursors = append(cursors, &runCursor{index: i, next: s.runReader(r)})
+	}
+	cursors = append(cursors, &runCursor{index: len(p.runs), next: memoryReader(p.values)})
+
+	h := cursors[:0]
+	for _, cursor := range cursors {
+		ok, err := cursor.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, cursor)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		key := h[0].key
+		var values []interface{}
+		for h.Len() > 0 && h[0].key == key {
+			cursor := h[0]
+			values = append(values, cursor.values...)
+			ok, err := cursor.advance()
+			if err != nil {
+				return err
+			}
+			if ok {
+				heap.Fix(&h, 0)
+			} else {
+				heap.Pop(&h)
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := reduceFunc(key, values); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runReader returns a function reading the keys of a spilled run with their values.
+func (s *shuffle) runReader(r io.Reader) func() (string, []interface{}, error) {
+	return func() (string, []interface{}, error) {
+		key, err := xbinary.ReadString(r)
+		if err != nil {
+			return "", nil, err
+		}
+		count, err := xbinary.ReadUint32(r)
+		if err != nil {
+			return "", nil, truncated(err)
+		}
+
+		values := make([]interface{}, count)
+		for i := range values {
+			if values[i], err = s.option.encoder.Decode(r); err != nil {
+				return "", nil, truncated(err)
+			}
+		}
+		return key, values, nil
+	}
+}
+
+// memoryReader returns a function reading the keys of values in order.
+func memoryReader(values map[string][]interface{}) func() (string, []interface{}, error) {
+	keys := sortedKeys(values)
+	return func() (string, []interface{}, error) {
+		if len(keys) == 0 {
+			return "", nil, io.EOF
+		}
+
+		key := keys[0]
+		keys = keys[1:]
+		return key, values[key], nil
+	}
+}
+
+// advance moves c to its next key, it returns false at the end of the run.
+func (c *runCursor) advance() (bool, error) {
+	key, values, err := c.next()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	c.key, c.values = key, values
+	return true, nil
+}
+
+func (h cursorHeap) Len() int {
+	return len(h)
+}
+
+func (h cursorHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].index < h[j].index
+}
+
+func (h cursorHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *cursorHeap) Push(x interface{}) {
+	*h = append(*h, x.(*runCursor))
+}
+
+func (h *cursorHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func sortedKeys(values map[string][]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// truncated reports an end of file in the middle of a record as an invalid length.
+func truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return xbinary.ErrInvalidLength
+	}
+	return err
+}
+
+func (s *shuffle) close() error {
+	var be xerror.BatchError
+	for _, p := range s.partitions {
+		if p.file != nil {
+			be.Add(p.file.Close())
+		}
+	}
+
+	if s.dir != "" {
+		be.Add(os.RemoveAll(s.dir))
+	}
+
+	return be.Err()
+}
@@ -0,0 +1,139 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xmapreduce
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func wordCount(t *testing.T, opts ...Option) map[string]int {
+	counts := map[string]int{}
+	err := MapReduceByKey(context.Background(), generateInts(1000), func(item interface{}, emit EmitFunc) {
+		i := item.(int)
+		emit(strconv.Itoa(i%10), uint32(i))
+	}, func(key string, values []interface{}) error {
+		counts[key] = len(values)
+		return nil
+	}, opts...)
+	assert.NoError(t, err)
+
+	return counts
+}
+
+func TestMapReduceByKey(t *testing.T) {
+	t.Run("in memory", func(t *testing.T) {
+		counts := wordCount(t, WithPartitions(4))
+		assert.Len(t, counts, 10)
+		for _, count := range counts {
+			assert.Equal(t, 100, count)
+		}
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "spill")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		counts := wordCount(t, WithPartitions(2), WithMemoryLimit(10), WithSpillDir(dir))
+		assert.Len(t, counts, 10)
+		for _, count := range counts {
+			assert.Equal(t, 100, count)
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Empty(t, files)
+	})
+
+	t.Run("merge runs", func(t *testing.T) {
+		// Every key is spilled in several runs, the values keep their order.
+		values := map[string][]interface{}{}
+		err := MapReduceByKey(context.Background(), generateInts(1000), func(item interface{}, emit EmitFunc) {
+			i := item.(int)
+			emit(strconv.Itoa(i%7), strconv.Itoa(i))
+		}, func(key string, vals []interface{}) error {
+			_, ok := values[key]
+			assert.False(t, ok, "key %s reduced twice", key)
+			values[key] = vals
+			return nil
+		}, WithWorkerSize(1), WithPartitions(3), WithMemoryLimit(5))
+		assert.NoError(t, err)
+
+		assert.Len(t, values, 7)
+		for key, vals := range values {
+			k, _ := strconv.Atoi(key)
+			expected := make([]interface{}, 0, len(vals))
+			for i := k; i < 1000; i += 7 {
+				expected = append(expected, strconv.Itoa(i))
+			}
+			assert.Equal(t, expected, vals)
+		}
+	})
+
+	t.Run("spill int values", func(t *testing.T) {
+		// The spilled values are read back with the type of the values kept in memory.
+		var sum int
+		err := MapReduceByKey(context.Background(), generateInts(100), func(item interface{}, emit EmitFunc) {
+			emit("key", item)
+		}, func(key string, vals []interface{}) error {
+			assert.Len(t, vals, 100)
+			for _, v := range vals {
+				sum += v.(int)
+			}
+			return nil
+		}, WithWorkerSize(1), WithMemoryLimit(3))
+		assert.NoError(t, err)
+		assert.Equal(t, 4950, sum)
+	})
+
+	t.Run("unsupported value", func(t *testing.T) {
+		err := MapReduceByKey(context.Background(), generateInts(100), func(item interface{}, emit EmitFunc) {
+			emit("key", struct{}{})
+		}, func(key string, values []interface{}) error {
+			return nil
+		}, WithMemoryLimit(1))
+		assert.Equal(t, ErrUnsupportedValue, err)
+	})
+
+	t.Run("reduce error", func(t *testing.T) {
+		errDummy := errors.New("dummy")
+		err := MapReduceByKey(context.Background(), generateInts(100), func(item interface{}, emit EmitFunc) {
+			emit("key", item)
+		}, func(key string, values []interface{}) error {
+			return errDummy
+		})
+		assert.Equal(t, errDummy, err)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		cancelFunc()
+		err := MapReduceByKey(ctx, generateInts(100), func(item interface{}, emit EmitFunc) {
+			emit("key", item)
+		}, func(key string, values []interface{}) error {
+			return nil
+		})
+		assert.Equal(t, context.Canceled, err)
+	})
+}
@@ -36,7 +36,11 @@ type (
 	ReducerFunc func(pipe <-chan interface{}, writer xbarrier.Writer, cancel func(error))
 
 	options struct {
-		workerSize int
+		workerSize  int
+		partitions  int
+		memoryLimit int
+		spillDir    string
+		encoder     Encoder
 	}
 
 	// Option defines the method to customize the mapreduce.
@@ -89,13 +93,13 @@ func buildSource(generateFunc GenerateFunc) chan interface{} {
 
 func doMap(ctx context.Context, mapFunc MapFunc, source <-chan interface{}, collector chan<- interface{}, option *options) {
 	waitGroup := sync.WaitGroup{}
+	writer := xbarrier.NewWriteBarrier(ctx, collector)
 
 	defer func() {
 		waitGroup.Wait()
-		close(collector)
+		writer.Close()
 	}()
 	worker := xworker.NewWorker(option.workerSize)
-	writer := xbarrier.NewWriteBarrier(ctx, collector)
 
 	for {
 		select {
@@ -20,6 +20,7 @@ import (
 	"context"
 	"github.com/chenquan/go-pkg/xbarrier"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
 	"testing"
 	"time"
 )
@@ -61,6 +62,33 @@ func TestMap(t *testing.T) {
 		assert.Equal(t, 10, i)
 	})
 
+	t.Run("abandoned", func(t *testing.T) {
+		defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		c := Map(ctx, func(source chan<- interface{}) {
+			for i := 0; i < 100; i++ {
+				select {
+				case <-ctx.Done():
+					return
+				case source <- i:
+				}
+			}
+		}, func(item interface{}, writer xbarrier.Writer) {
+			writer.Write(item)
+		}, WithWorkerSize(4))
+		<-c
+		// The mappers blocked on the collector exit without a reader.
+		cancelFunc()
+		assert.Eventually(t, func() bool {
+			select {
+			case _, ok := <-c:
+				return !ok
+			default:
+				return false
+			}
+		}, time.Second, time.Millisecond)
+	})
 }
 
 func TestMapStream(t *testing.T) {
@@ -0,0 +1,34 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xpersist
+
+import "github.com/chenquan/go-pkg/xbinary"
+
+var (
+	// ErrUnsupportedValue is returned when a Codec cannot encode a value.
+	ErrUnsupportedValue = xbinary.ErrUnsupportedValue
+	// ErrKeyTooLong is returned when a key is longer than 65535 bytes.
+	ErrKeyTooLong = xbinary.ErrInvalidLength
+)
+
+type (
+	// Codec writes the values of a SharedMap and reads them back.
+	Codec = xbinary.Codec
+
+	// BinaryCodec is the default Codec, see xbinary.BinaryCodec.
+	BinaryCodec = xbinary.BinaryCodec
+)
@@ -0,0 +1,494 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xpersist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chenquan/go-pkg/xbinary"
+	"github.com/chenquan/go-pkg/xerror"
+	"github.com/chenquan/go-pkg/xsync"
+)
+
+const (
+	snapshotPrefix = "snapshot-"
+	logPrefix      = "log-"
+	tmpSuffix      = ".tmp"
+
+	storeOp  byte = 1
+	deleteOp byte = 2
+	clearOp  byte = 3
+)
+
+type (
+	// Persister keeps a SharedMap in a directory, as the latest snapshot
+	// followed by an append-only log of the mutations made since the snapshot.
+	//
+	// The log only contains the mutations made through the methods of the SharedMap,
+	// see xsync.SharedMap.Watch.
+	// The watchers are notified after the mutation, concurrent mutations of a key may be notified
+	// in another order than they were applied. So a notification logs the state of the key read
+	// at that time rather than the mutation, which makes the last record of a key hold its latest state.
+	//
+	// A value the Codec can't encode isn't logged, the error is reported and the other mutations
+	// are still logged. Only a failed write to the log stops the logging, as the log may be torn.
+	Persister struct {
+		m       *xsync.SharedMap
+		dir     string
+		codec   Codec
+		onError func(err error)
+		unwatch func()
+
+		lock   sync.Mutex
+		log    *os.File
+		logSeq uint64
+		buf    bytes.Buffer
+		// err is the first error met, broken reports whether a write to the log failed.
+		err     error
+		broken  bool
+		closed  bool
+		snapMu  sync.Mutex
+		done    chan struct{}
+		stop    sync.Once
+		running sync.WaitGroup
+	}
+
+	// PersisterOption customizes a Persister.
+	PersisterOption func(*persisterOptions)
+
+	persisterOptions struct {
+		codec    Codec
+		interval time.Duration
+		onError  func(err error)
+	}
+)
+
+// WithPersisterCodec returns a PersisterOption that sets the Codec of the values, defaults to BinaryCodec.
+func WithPersisterCodec(codec Codec) PersisterOption {
+	return func(o *persisterOptions) {
+		o.codec = codec
+	}
+}
+
+// WithSnapshotInterval returns a PersisterOption that takes a snapshot every interval,
+// which compacts the log. By default snapshots are only taken by Snapshot.
+func WithSnapshotInterval(interval time.Duration) PersisterOption {
+	return func(o *persisterOptions) {
+		o.interval = interval
+	}
+}
+
+// WithOnError returns a PersisterOption that calls onError with every error met while logging
+// or taking the periodic snapshots. onError is called synchronously and must not call the Persister.
+func WithOnError(onError func(err error)) PersisterOption {
+	return func(o *persisterOptions) {
+		o.onError = onError
+	}
+}
+
+// Open restores m from the snapshot and the log found in dir, then logs the mutations of m to dir.
+// dir is created if it doesn't exist. The Persister must be closed after use.
+//
+// A record torn at the end of the log by a crash is ignored.
+func Open(m *xsync.SharedMap, dir string, opts ...PersisterOption) (*Persister, error) {
+	o := &persisterOptions{codec: BinaryCodec{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	p := &Persister{
+		m:       m,
+		dir:     dir,
+		codec:   o.codec,
+		onError: o.onError,
+		done:    make(chan struct{}),
+	}
+	lastSeq, err := p.restore()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.openLog(lastSeq + 1); err != nil {
+		return nil, err
+	}
+
+	p.unwatch = m.Watch(p.append)
+	if o.interval > 0 {
+		p.running.Add(1)
+		go p.run(o.interval)
+	}
+	return p, nil
+}
+
+// Snapshot writes a snapshot of the map and removes the log it supersedes.
+func (p *Persister) Snapshot() error {
+	p.snapMu.Lock()
+	defer p.snapMu.Unlock()
+
+	// Every mutation logged from now on goes to the new log, which is replayed on top of the snapshot.
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return os.ErrClosed
+	}
+	seq := p.logSeq + 1
+	err := p.rotateLocked(seq)
+	p.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err = p.writeSnapshot(seq); err != nil {
+		return err
+	}
+
+	return p.removeBefore(seq)
+}
+
+// Err returns the first error met while logging or taking the periodic snapshots.
+func (p *Persister) Err() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.err
+}
+
+// Close stops logging the mutations of the map, and returns the first error met while logging
+// or taking the periodic snapshots.
+func (p *Persister) Close() error {
+	p.stop.Do(func() {
+		p.unwatch()
+		close(p.done)
+	})
+	p.running.Wait()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	var be xerror.BatchError
+	if p.err != nil {
+		be.Add(p.err)
+	}
+	if err := p.log.Close(); err != nil {
+		be.Add(err)
+	}
+	return be.Err()
+}
+
+func (p *Persister) run(interval time.Duration) {
+	defer p.running.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			if err := p.Snapshot(); err != nil {
+				p.fail(err)
+			}
+		}
+	}
+}
+
+// append logs the state of the key of event, it's called by the watch of the map.
+func (p *Persister) append(event xsync.Event) {
+	var errs []error
+	p.lock.Lock()
+	if p.closed || p.broken {
+		p.lock.Unlock()
+		return
+	}
+
+	p.buf.Reset()
+	switch event.Type {
+	case xsync.EventStore, xsync.EventDelete:
+		if err := p.writeState(event.Key.(string)); err != nil {
+			// Skip the record which can't be encoded.
+			p.buf.Reset()
+			errs = append(errs, err)
+		}
+	case xsync.EventClear:
+		// The keys stored concurrently with Clear may have been notified before it.
+		p.buf.WriteByte(clearOp)
+		p.m.Range(func(key, value interface{}) bool {
+			n := p.buf.Len()
+			if err := writeRecord(&p.buf, storeOp, key.(string), value, p.codec); err != nil {
+				p.buf.Truncate(n)
+				errs = append(errs, err)
+			}
+			return true
+		})
+	}
+	if p.buf.Len() > 0 {
+		// A single write per notification, so that a crash tears the last record at most.
+		if _, err := p.log.Write(p.buf.Bytes()); err != nil {
+			p.broken = true
+			errs = append(errs, err)
+		}
+	}
+	for _, err := range errs {
+		p.setErrLocked(err)
+	}
+	p.lock.Unlock()
+
+	p.report(errs...)
+}
+
+// writeState writes a record of the current state of key.
+func (p *Persister) writeState(key string) error {
+	value, ok := p.m.Load(key)
+	if ok {
+		return writeRecord(&p.buf, storeOp, key, value, p.codec)
+	}
+
+	if err := xbinary.WriteTag(&p.buf, deleteOp); err != nil {
+		return err
+	}
+	return xbinary.WriteString(&p.buf, key)
+}
+
+func (p *Persister) fail(err error) {
+	p.lock.Lock()
+	p.setErrLocked(err)
+	p.lock.Unlock()
+
+	p.report(err)
+}
+
+func (p *Persister) setErrLocked(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *Persister) report(errs ...error) {
+	if p.onError == nil {
+		return
+	}
+
+	for _, err := range errs {
+		p.onError(err)
+	}
+}
+
+// restore loads the latest snapshot and replays the logs following it,
+// it returns the greatest sequence number found in dir.
+func (p *Persister) restore() (uint64, error) {
+	snapshots, logs, err := p.list()
+	if err != nil {
+		return 0, err
+	}
+
+	var snapshotSeq, lastSeq uint64
+	if len(snapshots) > 0 {
+		snapshotSeq = snapshots[len(snapshots)-1]
+		lastSeq = snapshotSeq
+		if err = p.loadSnapshot(snapshotSeq); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, seq := range logs {
+		if seq > lastSeq {
+			lastSeq = seq
+		}
+		if seq < snapshotSeq {
+			// Superseded by the snapshot, left by a crash before its removal.
+			continue
+		}
+		if err = p.replay(seq); err != nil {
+			return 0, err
+		}
+	}
+	return lastSeq, nil
+}
+
+func (p *Persister) loadSnapshot(seq uint64) error {
+	f, err := os.Open(p.path(snapshotPrefix, seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return Load(f, p.m, WithCodec(p.codec))
+}
+
+func (p *Persister) replay(seq uint64) error {
+	f, err := os.Open(p.path(logPrefix, seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		op, err := xbinary.ReadTag(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case storeOp:
+			key, value, err := readRecord(r, p.codec)
+			if err == io.ErrUnexpectedEOF {
+				// The last record was torn by a crash.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			p.m.Store(key, value)
+		case deleteOp:
+			key, err := xbinary.ReadString(r)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				// The last record was torn by a crash.
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			p.m.Delete(key)
+		case clearOp:
+			p.m.Clear()
+		default:
+			return fmt.Errorf("%s: invalid operation %d", p.path(logPrefix, seq), op)
+		}
+	}
+}
+
+func (p *Persister) writeSnapshot(seq uint64) error {
+	path := p.path(snapshotPrefix, seq)
+	f, err := os.Create(path + tmpSuffix)
+	if err != nil {
+		return err
+	}
+
+	err = Save(f, p.m, WithCodec(p.codec))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(path + tmpSuffix)
+		return err
+	}
+
+	return os.Rename(path+tmpSuffix, path)
+}
+
+func (p *Persister) openLog(seq uint64) error {
+	log, err := os.OpenFile(p.path(logPrefix, seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	p.log = log
+	p.logSeq = seq
+	return nil
+}
+
+func (p *Persister) rotateLocked(seq uint64) error {
+	prev := p.log
+	if err := p.openLog(seq); err != nil {
+		return err
+	}
+
+	return prev.Close()
+}
+
+// removeBefore removes the snapshots and the logs older than seq.
+func (p *Persister) removeBefore(seq uint64) error {
+	snapshots, logs, err := p.list()
+	if err != nil {
+		return err
+	}
+
+	var be xerror.BatchError
+	for _, s := range snapshots {
+		if s < seq {
+			be.Add(os.Remove(p.path(snapshotPrefix, s)))
+		}
+	}
+	for _, s := range logs {
+		if s < seq {
+			be.Add(os.Remove(p.path(logPrefix, s)))
+		}
+	}
+	return be.Err()
+}
+
+// list returns the sorted sequence numbers of the snapshots and the logs in dir.
+func (p *Persister) list() (snapshots, logs []uint64, err error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, tmpSuffix) {
+			continue
+		}
+
+		if seq, ok := parseSeq(name, snapshotPrefix); ok {
+			snapshots = append(snapshots, seq)
+		} else if seq, ok := parseSeq(name, logPrefix); ok {
+			logs = append(logs, seq)
+		}
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+	sort.Slice(logs, func(i, j int) bool { return logs[i] < logs[j] })
+	return snapshots, logs, nil
+}
+
+func (p *Persister) path(prefix string, seq uint64) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%s%020d", prefix, seq))
+}
+
+func parseSeq(name, prefix string) (uint64, bool) {
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+
+	seq, err := strconv.ParseUint(strings.TrimPrefix(name, prefix), 10, 64)
+	return seq, err == nil
+}
@@ -0,0 +1,264 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xpersist
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chenquan/go-pkg/xsync"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestPersister(t *testing.T) {
+	dir := t.TempDir()
+
+	m := newMap(100)
+	p, err := Open(m, dir)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Snapshot())
+	m.Store("a", "value")
+	m.Delete("0")
+	m.MDelete([]string{"1", "2"})
+	assert.NoError(t, p.Close())
+	assert.NoError(t, p.Close())
+	assert.Equal(t, os.ErrClosed, p.Snapshot())
+
+	restored := xsync.NewSharedMap()
+	p, err = Open(restored, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, m.Snapshot(), restored.Snapshot())
+
+	restored.Clear()
+	restored.Store("b", true)
+	assert.NoError(t, p.Close())
+
+	restored = xsync.NewSharedMap()
+	p, err = Open(restored, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"b": true}, restored.Snapshot())
+	assert.NoError(t, p.Close())
+}
+
+func TestPersister_ConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+
+	m := xsync.NewSharedMap()
+	p, err := Open(m, dir)
+	assert.NoError(t, err)
+
+	var wait sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wait.Add(1)
+		go func(g int) {
+			defer wait.Done()
+			for i := 0; i < 200; i++ {
+				key := strconv.Itoa(i % 4)
+				if i%5 == g%5 {
+					m.Delete(key)
+				} else {
+					m.Store(key, int64(g*1000+i))
+				}
+				if g == 0 && i%50 == 0 {
+					m.Clear()
+				}
+			}
+		}(g)
+	}
+	wait.Wait()
+	assert.NoError(t, p.Close())
+
+	restored := xsync.NewSharedMap()
+	p, err = Open(restored, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, m.Snapshot(), restored.Snapshot())
+	assert.NoError(t, p.Close())
+}
+
+func TestPersister_LateNotification(t *testing.T) {
+	dir := t.TempDir()
+
+	m := xsync.NewSharedMap()
+	delayed := make(chan struct{})
+	resume := make(chan struct{})
+	// Registered before the Persister, it delays the notification of the first Store.
+	unwatch := m.Watch(func(event xsync.Event) {
+		if event.Value == "first" {
+			close(delayed)
+			<-resume
+		}
+	})
+	defer unwatch()
+	p, err := Open(m, dir)
+	assert.NoError(t, err)
+
+	stored := make(chan struct{})
+	go func() {
+		m.Store("key", "first")
+		close(stored)
+	}()
+	<-delayed
+	m.Store("key", "second")
+	close(resume)
+	<-stored
+	assert.NoError(t, p.Close())
+
+	restored := xsync.NewSharedMap()
+	p, err = Open(restored, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "second"}, restored.Snapshot())
+	assert.NoError(t, p.Close())
+}
+
+func TestPersister_Compaction(t *testing.T) {
+	dir := t.TempDir()
+
+	p, err := Open(newMap(10), dir)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Snapshot())
+	assert.NoError(t, p.Snapshot())
+	assert.NoError(t, p.Close())
+
+	snapshots, logs, err := p.list()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{3}, snapshots)
+	assert.Equal(t, []uint64{3}, logs)
+
+	// A log superseded by the snapshot, left by a crash, is ignored.
+	assert.NoError(t, os.WriteFile(p.path(logPrefix, 2), []byte{clearOp}, 0o644))
+	restored := xsync.NewSharedMap()
+	p, err = Open(restored, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, restored.Len())
+	assert.NoError(t, p.Close())
+}
+
+func TestPersister_TornLog(t *testing.T) {
+	dir := t.TempDir()
+
+	m := xsync.NewSharedMap()
+	p, err := Open(m, dir)
+	assert.NoError(t, err)
+	m.Store("a", "value")
+	m.Store("b", "value")
+	m.Delete("a")
+	assert.NoError(t, p.Close())
+
+	logs, err := filepath.Glob(filepath.Join(dir, logPrefix+"*"))
+	assert.NoError(t, err)
+	assert.Len(t, logs, 1)
+	data, err := os.ReadFile(logs[0])
+	assert.NoError(t, err)
+
+	for _, cut := range []int{1, 2} {
+		assert.NoError(t, os.WriteFile(logs[0], data[:len(data)-cut], 0o644))
+		restored := xsync.NewSharedMap()
+		p, err = Open(restored, dir)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"a": "value", "b": "value"}, restored.Snapshot())
+		assert.NoError(t, p.Close())
+		// Reopening created a new empty log.
+		assert.NoError(t, os.Remove(p.path(logPrefix, p.logSeq)))
+	}
+
+	assert.NoError(t, os.WriteFile(logs[0], []byte{255}, 0o644))
+	_, err = Open(xsync.NewSharedMap(), dir)
+	assert.Error(t, err)
+}
+
+func TestPersister_SnapshotInterval(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	dir := t.TempDir()
+	m := xsync.NewSharedMap()
+	p, err := Open(m, dir, WithSnapshotInterval(time.Millisecond))
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		m.Store(strconv.Itoa(i), int64(i))
+	}
+
+	assert.Eventually(t, func() bool {
+		snapshots, _, err := p.list()
+		return err == nil && len(snapshots) > 0 && snapshots[0] > 2
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, p.Close())
+
+	restored := xsync.NewSharedMap()
+	p, err = Open(restored, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, m.Snapshot(), restored.Snapshot())
+	assert.NoError(t, p.Close())
+}
+
+func TestPersister_UnsupportedValue(t *testing.T) {
+	dir := t.TempDir()
+
+	m := xsync.NewSharedMap()
+	var reported []error
+	p, err := Open(m, dir, WithOnError(func(err error) {
+		reported = append(reported, err)
+	}))
+	assert.NoError(t, err)
+	m.Store("a", "value")
+	m.Store("bad", struct{}{})
+	m.Store(strings.Repeat("k", 1<<16), "value")
+	m.Store("b", "value")
+	assert.Equal(t, ErrUnsupportedValue, p.Err())
+	assert.Equal(t, []error{ErrUnsupportedValue, ErrKeyTooLong}, reported)
+	assert.Equal(t, ErrUnsupportedValue, p.Close())
+
+	// The record which couldn't be encoded is skipped, the next ones are logged.
+	restored := xsync.NewSharedMap()
+	p, err = Open(restored, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "value", "b": "value"}, restored.Snapshot())
+	assert.NoError(t, p.Close())
+}
+
+func TestPersister_FailedSnapshot(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	dir := t.TempDir()
+	m := xsync.NewSharedMap()
+	var failures int32
+	p, err := Open(m, dir, WithSnapshotInterval(time.Millisecond), WithOnError(func(err error) {
+		atomic.AddInt32(&failures, 1)
+	}))
+	assert.NoError(t, err)
+	m.Store("bad", struct{}{})
+	// The periodic snapshots fail too.
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&failures) > 1
+	}, time.Second, time.Millisecond)
+
+	m.Delete("bad")
+	m.Store("a", "value")
+	assert.Equal(t, ErrUnsupportedValue, p.Close())
+
+	restored := xsync.NewSharedMap()
+	p, err = Open(restored, dir)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "value"}, restored.Snapshot())
+	assert.NoError(t, p.Close())
+}
@@ -0,0 +1,299 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xpersist
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+
+	"github.com/chenquan/go-pkg/xbinary"
+	"github.com/chenquan/go-pkg/xsync"
+)
+
+const (
+	// Binary is a compact binary format, the values are written by the Codec.
+	Binary Format = iota
+	// JSON is a JSON object, the values are written by encoding/json.
+	JSON
+)
+
+const (
+	snapshotMagic   = "XSNP"
+	snapshotVersion = 1
+
+	endTag    byte = 0
+	recordTag byte = 1
+)
+
+var (
+	// ErrInvalidSnapshot is returned when a snapshot is malformed.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+)
+
+type (
+	// Format is the format of a snapshot.
+	Format int
+
+	// Option customizes the snapshots.
+	Option func(*options)
+
+	options struct {
+		format   Format
+		codec    Codec
+		newValue func() interface{}
+	}
+)
+
+// WithFormat returns an Option that sets the Format of the snapshots, defaults to Binary.
+func WithFormat(format Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithCodec returns an Option that sets the Codec of the Binary format, defaults to BinaryCodec.
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithJSONValue returns an Option that decodes the values of the JSON format into
+// the pointer returned by newValue, and stores the value it points to.
+// By default the values are decoded as interface{}.
+func WithJSONValue(newValue func() interface{}) Option {
+	return func(o *options) {
+		o.newValue = newValue
+	}
+}
+
+func loadOptions(opts ...Option) *options {
+	o := &options{format: Binary, codec: BinaryCodec{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Save writes the contents of m to w.
+//
+// Every shard of m is written as of a point in time, the shards are taken one after another
+// while the other shards may be written.
+func Save(w io.Writer, m *xsync.SharedMap, opts ...Option) error {
+	o := loadOptions(opts...)
+	bw := bufio.NewWriter(w)
+
+	var err error
+	switch o.format {
+	case Binary:
+		err = saveBinary(bw, m, o.codec)
+	case JSON:
+		err = saveJSON(bw, m)
+	default:
+		panic("unknown format")
+	}
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// Load stores the contents of a snapshot written by Save into m.
+// The keys of m missing from the snapshot are left untouched.
+func Load(r io.Reader, m *xsync.SharedMap, opts ...Option) error {
+	o := loadOptions(opts...)
+	br := bufio.NewReader(r)
+
+	switch o.format {
+	case Binary:
+		return loadBinary(br, m, o.codec)
+	case JSON:
+		return loadJSON(br, m, o.newValue)
+	default:
+		panic("unknown format")
+	}
+}
+
+func saveBinary(w io.Writer, m *xsync.SharedMap, codec Codec) (err error) {
+	if _, err = io.WriteString(w, snapshotMagic); err != nil {
+		return err
+	}
+	if err = xbinary.WriteTag(w, snapshotVersion); err != nil {
+		return err
+	}
+
+	m.SnapshotShards(func(snapshot map[string]interface{}) bool {
+		for key, value := range snapshot {
+			if err = writeRecord(w, recordTag, key, value, codec); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return xbinary.WriteTag(w, endTag)
+}
+
+func loadBinary(r io.Reader, m *xsync.SharedMap, codec Codec) error {
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return ErrInvalidSnapshot
+	}
+	if string(header[:len(snapshotMagic)]) != snapshotMagic || header[len(snapshotMagic)] != snapshotVersion {
+		return ErrInvalidSnapshot
+	}
+
+	for {
+		tag, err := xbinary.ReadTag(r)
+		if err == io.EOF {
+			// The snapshot was truncated.
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+
+		switch tag {
+		case endTag:
+			return nil
+		case recordTag:
+			key, value, err := readRecord(r, codec)
+			if err != nil {
+				return err
+			}
+			m.Store(key, value)
+		default:
+			return ErrInvalidSnapshot
+		}
+	}
+}
+
+func writeRecord(w io.Writer, tag byte, key string, value interface{}, codec Codec) error {
+	if err := xbinary.WriteTag(w, tag); err != nil {
+		return err
+	}
+	if err := xbinary.WriteString(w, key); err != nil {
+		return err
+	}
+
+	return codec.Encode(w, value)
+}
+
+func readRecord(r io.Reader, codec Codec) (string, interface{}, error) {
+	key, err := xbinary.ReadString(r)
+	if err != nil {
+		return "", nil, unexpectedEOF(err)
+	}
+
+	value, err := codec.Decode(r)
+	if err != nil {
+		return "", nil, unexpectedEOF(err)
+	}
+	return key, value, nil
+}
+
+// unexpectedEOF reports an end of file in the middle of a record as io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func saveJSON(w io.Writer, m *xsync.SharedMap) (err error) {
+	if _, err = io.WriteString(w, "{"); err != nil {
+		return err
+	}
+
+	first := true
+	m.SnapshotShards(func(snapshot map[string]interface{}) bool {
+		for key, value := range snapshot {
+			if err = writeJSONMember(w, key, value, first); err != nil {
+				return false
+			}
+			first = false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "}")
+	return err
+}
+
+func writeJSONMember(w io.Writer, key string, value interface{}, first bool) error {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if !first {
+		k = append([]byte{','}, k...)
+	}
+	k = append(k, ':')
+	if _, err = w.Write(k); err != nil {
+		return err
+	}
+
+	_, err = w.Write(v)
+	return err
+}
+
+func loadJSON(r io.Reader, m *xsync.SharedMap, newValue func() interface{}) error {
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil {
+		return err
+	} else if token != json.Delim('{') {
+		return ErrInvalidSnapshot
+	}
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := token.(string)
+
+		var value interface{}
+		if newValue != nil {
+			ptr := newValue()
+			if err = decoder.Decode(ptr); err != nil {
+				return err
+			}
+			value = reflect.ValueOf(ptr).Elem().Interface()
+		} else if err = decoder.Decode(&value); err != nil {
+			return err
+		}
+		m.Store(key, value)
+	}
+
+	_, err := decoder.Token()
+	return err
+}
@@ -0,0 +1,107 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xpersist
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/chenquan/go-pkg/xsync"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMap(n int) *xsync.SharedMap {
+	m := xsync.NewSharedMap(xsync.WithShardBlockSize(4))
+	for i := 0; i < n; i++ {
+		m.Store(strconv.Itoa(i), int64(i))
+	}
+	return m
+}
+
+func TestSaveLoad(t *testing.T) {
+	m := newMap(100)
+	m.Store("bytes", []byte("value"))
+	m.Store("nil", nil)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Save(&buf, m))
+
+	restored := xsync.NewSharedMap()
+	restored.Store("untouched", "value")
+	assert.NoError(t, Load(&buf, restored))
+	assert.Equal(t, 103, restored.Len())
+	snapshot := restored.Snapshot()
+	delete(snapshot, "untouched")
+	assert.Equal(t, m.Snapshot(), snapshot)
+}
+
+func TestSaveLoad_Invalid(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, Save(&buf, newMap(10)))
+	data := buf.Bytes()
+
+	m := xsync.NewSharedMap()
+	assert.Equal(t, ErrInvalidSnapshot, Load(bytes.NewReader([]byte("XSN")), m))
+	assert.Equal(t, ErrInvalidSnapshot, Load(bytes.NewReader([]byte("XSNP\x02")), m))
+	assert.Equal(t, io.ErrUnexpectedEOF, Load(bytes.NewReader(data[:len(data)-1]), m))
+	assert.Equal(t, io.ErrUnexpectedEOF, Load(bytes.NewReader(data[:len(data)-3]), m))
+
+	unsupported := xsync.NewSharedMap()
+	unsupported.Store("key", struct{}{})
+	assert.Equal(t, ErrUnsupportedValue, Save(&buf, unsupported))
+
+	assert.Panics(t, func() {
+		_ = Save(&buf, m, WithFormat(Format(-1)))
+	})
+}
+
+func TestSaveLoad_JSON(t *testing.T) {
+	m := xsync.NewSharedMap()
+	m.Store("a", 1)
+	m.Store("b", "value")
+
+	var buf bytes.Buffer
+	assert.NoError(t, Save(&buf, m, WithFormat(JSON)))
+
+	restored := xsync.NewSharedMap()
+	assert.NoError(t, Load(bytes.NewReader(buf.Bytes()), restored, WithFormat(JSON)))
+	assert.Equal(t, map[string]interface{}{"a": float64(1), "b": "value"}, restored.Snapshot())
+
+	type point struct {
+		X, Y int
+	}
+	m = xsync.NewSharedMap()
+	m.Store("p", point{X: 1, Y: 2})
+	buf.Reset()
+	assert.NoError(t, Save(&buf, m, WithFormat(JSON)))
+
+	restored = xsync.NewSharedMap()
+	assert.NoError(t, Load(&buf, restored, WithFormat(JSON), WithJSONValue(func() interface{} {
+		return new(point)
+	})))
+	assert.Equal(t, map[string]interface{}{"p": point{X: 1, Y: 2}}, restored.Snapshot())
+
+	assert.Equal(t, ErrInvalidSnapshot, Load(bytes.NewReader([]byte("[]")), restored, WithFormat(JSON)))
+	assert.Error(t, Load(bytes.NewReader([]byte(`{"a":`)), restored, WithFormat(JSON)))
+
+	empty := xsync.NewSharedMap()
+	buf.Reset()
+	assert.NoError(t, Save(&buf, empty, WithFormat(JSON)))
+	assert.Equal(t, "{}", buf.String())
+}
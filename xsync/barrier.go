@@ -0,0 +1,131 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrBrokenBarrier is returned by CyclicBarrier.Await when a party gave up or the barrier was reset.
+	ErrBrokenBarrier = errors.New("broken barrier")
+)
+
+type (
+	// CyclicBarrier lets a number of parties wait for each other, it's reused once all parties arrived.
+	CyclicBarrier struct {
+		parties    int
+		action     func()
+		mu         sync.Mutex
+		generation *barrierGeneration
+	}
+
+	barrierGeneration struct {
+		arrived int
+		broken  bool
+		done    chan struct{}
+	}
+)
+
+// NewCyclicBarrier returns a CyclicBarrier of the given number of parties.
+// If action is not nil, it's called by the last arriving party before the others are released.
+func NewCyclicBarrier(parties int, action func()) *CyclicBarrier {
+	if parties < 1 {
+		panic("parties should be greater than 0")
+	}
+
+	return &CyclicBarrier{
+		parties:    parties,
+		action:     action,
+		generation: newBarrierGeneration(),
+	}
+}
+
+func newBarrierGeneration() *barrierGeneration {
+	return &barrierGeneration{done: make(chan struct{})}
+}
+
+// Await waits until all parties called Await, and returns the arrival index of the caller:
+// parties-1 for the first party, 0 for the last one.
+//
+// If ctx is done first, Await returns ctx.Err() and breaks the barrier:
+// the other waiting parties return ErrBrokenBarrier, and the barrier starts over.
+func (b *CyclicBarrier) Await(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	generation := b.generation
+	index := b.parties - 1 - generation.arrived
+	generation.arrived++
+
+	if generation.arrived == b.parties {
+		b.generation = newBarrierGeneration()
+		b.mu.Unlock()
+
+		if b.action != nil {
+			b.action()
+		}
+		close(generation.done)
+		return index, nil
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-generation.done:
+		return generation.result(index)
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.generation != generation {
+		// All parties arrived meanwhile.
+		<-generation.done
+		return generation.result(index)
+	}
+
+	b.breakLocked()
+	return 0, ctx.Err()
+}
+
+// Reset breaks the barrier: the waiting parties return ErrBrokenBarrier, and the barrier starts over.
+func (b *CyclicBarrier) Reset() {
+	b.mu.Lock()
+	b.breakLocked()
+	b.mu.Unlock()
+}
+
+// Waiting returns the number of parties waiting at the barrier.
+func (b *CyclicBarrier) Waiting() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.generation.arrived
+}
+
+func (b *CyclicBarrier) breakLocked() {
+	generation := b.generation
+	generation.broken = true
+	close(generation.done)
+	b.generation = newBarrierGeneration()
+}
+
+func (g *barrierGeneration) result(index int) (int, error) {
+	if g.broken {
+		return 0, ErrBrokenBarrier
+	}
+	return index, nil
+}
@@ -0,0 +1,99 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestCyclicBarrier(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var (
+		actions int32
+		wait    sync.WaitGroup
+		lock    sync.Mutex
+		indexes []int
+	)
+	barrier := NewCyclicBarrier(3, func() {
+		atomic.AddInt32(&actions, 1)
+	})
+	for g := 0; g < 3; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for round := 0; round < 10; round++ {
+				index, err := barrier.Await(context.Background())
+				assert.NoError(t, err)
+				// Every party of the round arrived, and the action ran.
+				assert.GreaterOrEqual(t, atomic.LoadInt32(&actions), int32(round+1))
+				if round == 0 {
+					lock.Lock()
+					indexes = append(indexes, index)
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	wait.Wait()
+
+	assert.Equal(t, int32(10), atomic.LoadInt32(&actions))
+	sort.Ints(indexes)
+	assert.Equal(t, []int{0, 1, 2}, indexes)
+	assert.Panics(t, func() {
+		NewCyclicBarrier(0, nil)
+	})
+}
+
+func TestCyclicBarrier_Broken(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	barrier := NewCyclicBarrier(3, nil)
+	waiting := make(chan error)
+	go func() {
+		_, err := barrier.Await(context.Background())
+		waiting <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return barrier.Waiting() == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := barrier.Await(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, ErrBrokenBarrier, <-waiting)
+	assert.Equal(t, 0, barrier.Waiting())
+
+	go func() {
+		_, err := barrier.Await(context.Background())
+		waiting <- err
+	}()
+	assert.Eventually(t, func() bool {
+		return barrier.Waiting() == 1
+	}, time.Second, time.Millisecond)
+	barrier.Reset()
+	assert.Equal(t, ErrBrokenBarrier, <-waiting)
+}
@@ -0,0 +1,90 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Cond is a condition variable like sync.Cond, whose waits can be cancelled by a context.
+type Cond struct {
+	// L is held while observing or changing the condition.
+	L sync.Locker
+
+	mu      sync.Mutex
+	waiters list.List
+}
+
+// NewCond returns a Cond with Locker l.
+func NewCond(l sync.Locker) *Cond {
+	return &Cond{L: l}
+}
+
+// Wait unlocks c.L and waits until it's woken up by Signal or Broadcast, or until ctx is done,
+// then locks c.L again before returning. It returns ctx.Err() if ctx is done first.
+//
+// Like sync.Cond.Wait, Wait is usually called in a loop checking the condition.
+func (c *Cond) Wait(ctx context.Context) error {
+	ready := make(chan struct{})
+	c.mu.Lock()
+	element := c.waiters.PushBack(ready)
+	c.mu.Unlock()
+
+	c.L.Unlock()
+	defer c.L.Lock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-ready:
+		// Woken up meanwhile, pass the signal on as it's not consumed.
+		c.signalLocked()
+	default:
+		c.waiters.Remove(element)
+	}
+	return ctx.Err()
+}
+
+// Signal wakes up one goroutine waiting on c, if there is any.
+func (c *Cond) Signal() {
+	c.mu.Lock()
+	c.signalLocked()
+	c.mu.Unlock()
+}
+
+// Broadcast wakes up all goroutines waiting on c.
+func (c *Cond) Broadcast() {
+	c.mu.Lock()
+	for c.waiters.Len() > 0 {
+		c.signalLocked()
+	}
+	c.mu.Unlock()
+}
+
+func (c *Cond) signalLocked() {
+	if front := c.waiters.Front(); front != nil {
+		close(c.waiters.Remove(front).(chan struct{}))
+	}
+}
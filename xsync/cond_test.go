@@ -0,0 +1,102 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestCond(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var lock sync.Mutex
+	cond := NewCond(&lock)
+	ready := false
+
+	var wait sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			lock.Lock()
+			defer lock.Unlock()
+			for !ready {
+				assert.NoError(t, cond.Wait(context.Background()))
+			}
+		}()
+	}
+
+	time.Sleep(time.Millisecond * 10)
+	lock.Lock()
+	ready = true
+	cond.Broadcast()
+	lock.Unlock()
+	wait.Wait()
+}
+
+func TestCond_Signal(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var lock sync.Mutex
+	cond := NewCond(&lock)
+	queue := 0
+
+	done := make(chan struct{})
+	for g := 0; g < 2; g++ {
+		go func() {
+			lock.Lock()
+			defer lock.Unlock()
+			for queue == 0 {
+				assert.NoError(t, cond.Wait(context.Background()))
+			}
+			queue--
+			done <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < 2; i++ {
+		lock.Lock()
+		queue++
+		cond.Signal()
+		lock.Unlock()
+		<-done
+	}
+	cond.Signal()
+}
+
+func TestCond_WaitContext(t *testing.T) {
+	var lock sync.Mutex
+	cond := NewCond(&lock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	lock.Lock()
+	assert.Equal(t, context.DeadlineExceeded, cond.Wait(ctx))
+	// The lock is held again.
+	assert.False(t, lock.TryLock())
+	lock.Unlock()
+
+	cond.mu.Lock()
+	assert.Equal(t, 0, cond.waiters.Len())
+	cond.mu.Unlock()
+}
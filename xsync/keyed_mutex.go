@@ -0,0 +1,263 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// errKeyLocked is returned by lock when try is set and the key cannot be locked at once.
+	errKeyLocked = errors.New("key locked")
+)
+
+type (
+	// KeyedMutex is a mutual exclusion lock per key.
+	//
+	// The keys are striped like the segments of a SharedMap, the lock of a key only exists
+	// while it's held or awaited, so the memory doesn't grow with the number of keys.
+	KeyedMutex struct {
+		locks keyedLocks
+	}
+
+	// KeyedRWMutex is a reader/writer mutual exclusion lock per key, see KeyedMutex.
+	// A waiting writer blocks the new readers of its key.
+	KeyedRWMutex struct {
+		locks keyedLocks
+	}
+
+	keyedLocks struct {
+		stripes []keyedStripe
+		mask    uint64
+		hasher  Hasher
+	}
+
+	keyedStripe struct {
+		mu      sync.Mutex
+		entries map[string]*keyedEntry
+	}
+
+	// keyedEntry is the lock of a key, it's guarded by the lock of its stripe.
+	keyedEntry struct {
+		// refs is the number of holders and waiters, the entry is removed once it reaches 0.
+		refs           int
+		readers        int
+		writer         bool
+		waitingWriters int
+		// changed is closed and replaced when the lock is released.
+		changed chan struct{}
+	}
+)
+
+// NewKeyedMutex returns a KeyedMutex.
+// The SharedMapOptions taken into account are WithShardBlockSize and WithHasher.
+func NewKeyedMutex(opts ...SharedMapOption) *KeyedMutex {
+	return &KeyedMutex{locks: newKeyedLocks(opts...)}
+}
+
+// Lock locks key.
+func (m *KeyedMutex) Lock(key string) {
+	_ = m.locks.lock(context.Background(), key, true, false)
+}
+
+// TryLock tries to lock key and reports whether it succeeded.
+func (m *KeyedMutex) TryLock(key string) bool {
+	return m.locks.lock(context.Background(), key, true, true) == nil
+}
+
+// LockContext locks key, or returns ctx.Err() if ctx is done first.
+func (m *KeyedMutex) LockContext(ctx context.Context, key string) error {
+	return m.locks.lock(ctx, key, true, false)
+}
+
+// Unlock unlocks key.
+func (m *KeyedMutex) Unlock(key string) {
+	m.locks.unlock(key, true)
+}
+
+// NewKeyedRWMutex returns a KeyedRWMutex.
+// The SharedMapOptions taken into account are WithShardBlockSize and WithHasher.
+func NewKeyedRWMutex(opts ...SharedMapOption) *KeyedRWMutex {
+	return &KeyedRWMutex{locks: newKeyedLocks(opts...)}
+}
+
+// Lock locks key for writing.
+func (m *KeyedRWMutex) Lock(key string) {
+	_ = m.locks.lock(context.Background(), key, true, false)
+}
+
+// TryLock tries to lock key for writing and reports whether it succeeded.
+func (m *KeyedRWMutex) TryLock(key string) bool {
+	return m.locks.lock(context.Background(), key, true, true) == nil
+}
+
+// LockContext locks key for writing, or returns ctx.Err() if ctx is done first.
+func (m *KeyedRWMutex) LockContext(ctx context.Context, key string) error {
+	return m.locks.lock(ctx, key, true, false)
+}
+
+// Unlock unlocks key for writing.
+func (m *KeyedRWMutex) Unlock(key string) {
+	m.locks.unlock(key, true)
+}
+
+// RLock locks key for reading.
+func (m *KeyedRWMutex) RLock(key string) {
+	_ = m.locks.lock(context.Background(), key, false, false)
+}
+
+// TryRLock tries to lock key for reading and reports whether it succeeded.
+func (m *KeyedRWMutex) TryRLock(key string) bool {
+	return m.locks.lock(context.Background(), key, false, true) == nil
+}
+
+// RLockContext locks key for reading, or returns ctx.Err() if ctx is done first.
+func (m *KeyedRWMutex) RLockContext(ctx context.Context, key string) error {
+	return m.locks.lock(ctx, key, false, false)
+}
+
+// RUnlock unlocks key for reading.
+func (m *KeyedRWMutex) RUnlock(key string) {
+	m.locks.unlock(key, false)
+}
+
+func newKeyedLocks(opts ...SharedMapOption) keyedLocks {
+	options := new(sharedMapOptions)
+	options.shardBlockSize = 32
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.hasher == nil {
+		options.hasher = fnv32Hasher
+	}
+
+	if options.shardBlockSize <= 0 {
+		panic("error")
+	}
+	stripes := make([]keyedStripe, getShardBlockSize(options.shardBlockSize))
+	for i := range stripes {
+		stripes[i].entries = make(map[string]*keyedEntry)
+	}
+	return keyedLocks{
+		stripes: stripes,
+		mask:    uint64(len(stripes) - 1),
+		hasher:  options.hasher,
+	}
+}
+
+func (l *keyedLocks) lock(ctx context.Context, key string, write, try bool) error {
+	stripe := &l.stripes[l.hasher(key)&l.mask]
+	stripe.mu.Lock()
+	e, ok := stripe.entries[key]
+	if !ok {
+		e = &keyedEntry{changed: make(chan struct{})}
+		stripe.entries[key] = e
+	}
+	e.refs++
+
+	waiting := false
+	for {
+		if write && !e.writer && e.readers == 0 {
+			e.writer = true
+			if waiting {
+				e.waitingWriters--
+			}
+			stripe.mu.Unlock()
+			return nil
+		}
+		if !write && !e.writer && e.waitingWriters == 0 {
+			e.readers++
+			stripe.mu.Unlock()
+			return nil
+		}
+
+		if try {
+			stripe.releaseLocked(key, e)
+			stripe.mu.Unlock()
+			return errKeyLocked
+		}
+		if write && !waiting {
+			e.waitingWriters++
+			waiting = true
+		}
+
+		changed := e.changed
+		stripe.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			stripe.mu.Lock()
+			if waiting {
+				// The readers blocked by this writer may proceed.
+				e.waitingWriters--
+				e.broadcast()
+			}
+			stripe.releaseLocked(key, e)
+			stripe.mu.Unlock()
+			return ctx.Err()
+		}
+		stripe.mu.Lock()
+	}
+}
+
+func (l *keyedLocks) unlock(key string, write bool) {
+	stripe := &l.stripes[l.hasher(key)&l.mask]
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	e, ok := stripe.entries[key]
+	if !ok || write && !e.writer || !write && e.readers == 0 {
+		panic("unlock of unlocked key")
+	}
+
+	if write {
+		e.writer = false
+	} else {
+		e.readers--
+	}
+	e.broadcast()
+	stripe.releaseLocked(key, e)
+}
+
+// len returns the number of keys held or awaited.
+func (l *keyedLocks) len() int {
+	n := 0
+	for i := range l.stripes {
+		l.stripes[i].mu.Lock()
+		n += len(l.stripes[i].entries)
+		l.stripes[i].mu.Unlock()
+	}
+	return n
+}
+
+// releaseLocked drops a reference of e, and removes it once unused.
+func (s *keyedStripe) releaseLocked(key string, e *keyedEntry) {
+	e.refs--
+	if e.refs == 0 {
+		delete(s.entries, key)
+	}
+}
+
+// broadcast wakes up the waiters of e.
+func (e *keyedEntry) broadcast() {
+	if e.refs > 1 {
+		close(e.changed)
+		e.changed = make(chan struct{})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	m := NewKeyedMutex(WithShardBlockSize(4))
+	m.Lock("a")
+	assert.False(t, m.TryLock("a"))
+	assert.True(t, m.TryLock("b"))
+	assert.Equal(t, 2, m.locks.len())
+
+	m.Unlock("a")
+	m.Unlock("b")
+	assert.Equal(t, 0, m.locks.len())
+	assert.Panics(t, func() {
+		m.Unlock("a")
+	})
+}
+
+func TestKeyedMutex_Concurrent(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	m := NewKeyedMutex(WithShardBlockSize(2))
+	counts := make([]int, 10)
+	var wait sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				key := i % len(counts)
+				m.Lock(strconv.Itoa(key))
+				counts[key]++
+				m.Unlock(strconv.Itoa(key))
+			}
+		}()
+	}
+	wait.Wait()
+
+	for _, count := range counts {
+		assert.Equal(t, 800, count)
+	}
+	assert.Equal(t, 0, m.locks.len())
+}
+
+func TestKeyedMutex_LockContext(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	m := NewKeyedMutex()
+	m.Lock("a")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, m.LockContext(ctx, "a"))
+	assert.Equal(t, 1, m.locks.len())
+
+	locked := make(chan struct{})
+	go func() {
+		assert.NoError(t, m.LockContext(context.Background(), "a"))
+		close(locked)
+	}()
+	time.Sleep(time.Millisecond * 10)
+	m.Unlock("a")
+	<-locked
+	m.Unlock("a")
+	assert.Equal(t, 0, m.locks.len())
+}
+
+func TestKeyedRWMutex(t *testing.T) {
+	m := NewKeyedRWMutex()
+	m.RLock("a")
+	assert.True(t, m.TryRLock("a"))
+	assert.False(t, m.TryLock("a"))
+	m.RUnlock("a")
+	m.RUnlock("a")
+	assert.Panics(t, func() {
+		m.RUnlock("a")
+	})
+
+	m.Lock("a")
+	assert.False(t, m.TryRLock("a"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, m.RLockContext(ctx, "a"))
+	m.Unlock("a")
+	assert.Equal(t, 0, m.locks.len())
+}
+
+func TestKeyedRWMutex_WriterPreference(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	m := NewKeyedRWMutex()
+	m.RLock("a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := make(chan error)
+	go func() {
+		writer <- m.LockContext(ctx, "a")
+	}()
+
+	// The waiting writer blocks the new readers.
+	assert.Eventually(t, func() bool {
+		return !m.TryRLock("a")
+	}, time.Second, time.Millisecond)
+
+	reader := make(chan struct{})
+	go func() {
+		m.RLock("a")
+		close(reader)
+	}()
+
+	// Giving up the writer releases the blocked reader.
+	cancel()
+	assert.Equal(t, context.Canceled, <-writer)
+	<-reader
+	m.RUnlock("a")
+	m.RUnlock("a")
+	assert.Equal(t, 0, m.locks.len())
+}
+
+func TestKeyedRWMutex_Concurrent(t *testing.T) {
+	m := NewKeyedRWMutex(WithShardBlockSize(2))
+	var (
+		wait    sync.WaitGroup
+		value   int
+		readers int32
+	)
+	for g := 0; g < 8; g++ {
+		wait.Add(2)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				m.RLock("key")
+				atomic.AddInt32(&readers, 1)
+				_ = value
+				atomic.AddInt32(&readers, -1)
+				m.RUnlock("key")
+			}
+		}()
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 100; i++ {
+				m.Lock("key")
+				assert.Equal(t, int32(0), atomic.LoadInt32(&readers))
+				value++
+				m.Unlock("key")
+			}
+		}()
+	}
+	wait.Wait()
+	assert.Equal(t, 800, value)
+	assert.Equal(t, 0, m.locks.len())
+}
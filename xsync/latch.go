@@ -0,0 +1,88 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// CountDownLatch lets goroutines wait until a count of events happened.
+type CountDownLatch struct {
+	count int64
+	done  chan struct{}
+}
+
+// NewCountDownLatch returns a CountDownLatch waiting for count events.
+func NewCountDownLatch(count int) *CountDownLatch {
+	if count < 0 {
+		panic("count should not be negative")
+	}
+
+	latch := &CountDownLatch{count: int64(count), done: make(chan struct{})}
+	if count == 0 {
+		close(latch.done)
+	}
+	return latch
+}
+
+// CountDown decrements the count, and releases the waiters when it reaches 0.
+// CountDown does nothing once the count is 0.
+func (l *CountDownLatch) CountDown() {
+	for {
+		count := atomic.LoadInt64(&l.count)
+		if count == 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&l.count, count, count-1) {
+			if count == 1 {
+				close(l.done)
+			}
+			return
+		}
+	}
+}
+
+// Count returns the current count.
+func (l *CountDownLatch) Count() int {
+	return int(atomic.LoadInt64(&l.count))
+}
+
+// Await waits until the count reaches 0, or returns ctx.Err() if ctx is done first.
+func (l *CountDownLatch) Await(ctx context.Context) error {
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AwaitTimeout waits until the count reaches 0 for at most timeout,
+// and reports whether the count reached 0.
+func (l *CountDownLatch) AwaitTimeout(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-l.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
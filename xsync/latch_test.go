@@ -0,0 +1,59 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestCountDownLatch(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	latch := NewCountDownLatch(3)
+	assert.False(t, latch.AwaitTimeout(time.Millisecond))
+
+	var wait sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			latch.CountDown()
+		}()
+	}
+	assert.NoError(t, latch.Await(context.Background()))
+	assert.True(t, latch.AwaitTimeout(time.Millisecond))
+	assert.Equal(t, 0, latch.Count())
+	wait.Wait()
+
+	latch.CountDown()
+	assert.Equal(t, 0, latch.Count())
+
+	assert.NoError(t, NewCountDownLatch(0).Await(context.Background()))
+	assert.Panics(t, func() {
+		NewCountDownLatch(-1)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, NewCountDownLatch(1).Await(ctx))
+}
@@ -59,6 +59,14 @@ type (
 		// map, the dirty map will be promoted to the read map (in the unamended
 		// state) and the next store to the map will make a new dirty copy.
 		misses int
+
+		// count points to the number of present values in the entries created since the last Clear.
+		// Every entry holds the counter of the generation it was created in, so entries dropped
+		// by Clear never change the count of the current generation.
+		count unsafe.Pointer // *int64
+
+		// watchers are notified after every mutation.
+		watchers watchers
 	}
 	// readOnly is an immutable struct stored atomically in the Map.read field.
 	readOnly struct {
@@ -87,11 +95,25 @@ type (
 		// only after first setting m.dirty[key] = e so that lookups using the dirty
 		// map find the entry.
 		p unsafe.Pointer // *interface{}
+
+		// count points to the counter of the generation the entry was created in.
+		count *int64
 	}
 )
 
-func newEntry(i interface{}) *entry {
-	return &entry{p: unsafe.Pointer(&i)}
+func newEntry(i interface{}, count *int64) *entry {
+	atomic.AddInt64(count, 1)
+	return &entry{p: unsafe.Pointer(&i), count: count}
+}
+
+// countLocked returns the counter of the current generation, creating it if necessary.
+func (m *Map) countLocked() *int64 {
+	count := (*int64)(atomic.LoadPointer(&m.count))
+	if count == nil {
+		count = new(int64)
+		atomic.StorePointer(&m.count, unsafe.Pointer(count))
+	}
+	return count
 }
 
 // Load returns the value stored in the map for a key, or nil if no
@@ -132,13 +154,24 @@ func (e *entry) load() (value interface{}, ok bool) {
 
 // Store sets the value for a key.
 func (m *Map) Store(key, value interface{}) {
+	m.store(key, value)
+	m.watchers.notify(EventStore, key, value)
+}
+
+func (m *Map) store(key, value interface{}) {
 	read, _ := m.read.Load().(readOnly)
 	if e, ok := read.m[key]; ok && e.tryStore(&value) {
 		return
 	}
 
 	m.mu.Lock()
-	read, _ = m.read.Load().(readOnly)
+	m.storeSlowLocked(key, value)
+	m.mu.Unlock()
+}
+
+// storeSlowLocked stores a value which couldn't be stored without mu.
+func (m *Map) storeSlowLocked(key, value interface{}) {
+	read, _ := m.read.Load().(readOnly)
 	if e, ok := read.m[key]; ok {
 		if e.unexpungeLocked() {
 			// The entry was previously expunged, which implies that there is a
@@ -155,9 +188,8 @@ func (m *Map) Store(key, value interface{}) {
 			m.dirtyLocked()
 			m.read.Store(readOnly{m: read.m, amended: true})
 		}
-		m.dirty[key] = newEntry(value)
+		m.dirty[key] = newEntry(value, m.countLocked())
 	}
-	m.mu.Unlock()
 }
 
 // tryStore stores a value if the entry has not been expunged.
@@ -171,6 +203,9 @@ func (e *entry) tryStore(i *interface{}) bool {
 			return false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(i)) {
+			if p == nil {
+				atomic.AddInt64(e.count, 1)
+			}
 			return true
 		}
 	}
@@ -188,13 +223,23 @@ func (e *entry) unexpungeLocked() (wasExpunged bool) {
 //
 // The entry must be known not to be expunged.
 func (e *entry) storeLocked(i *interface{}) {
-	atomic.StorePointer(&e.p, unsafe.Pointer(i))
+	if atomic.SwapPointer(&e.p, unsafe.Pointer(i)) == nil {
+		atomic.AddInt64(e.count, 1)
+	}
 }
 
 // LoadOrStore returns the existing value for the key if present.
 // Otherwise, it stores and returns the given value.
 // The loaded result is true if the value was loaded, false if stored.
 func (m *Map) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	actual, loaded = m.loadOrStore(key, value)
+	if !loaded {
+		m.watchers.notify(EventStore, key, actual)
+	}
+	return actual, loaded
+}
+
+func (m *Map) loadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
 	// Avoid locking if it's a clean hit.
 	read, _ := m.read.Load().(readOnly)
 	if e, ok := read.m[key]; ok {
@@ -221,7 +266,7 @@ func (m *Map) LoadOrStore(key, value interface{}) (actual interface{}, loaded bo
 			m.dirtyLocked()
 			m.read.Store(readOnly{m: read.m, amended: true})
 		}
-		m.dirty[key] = newEntry(value)
+		m.dirty[key] = newEntry(value, m.countLocked())
 		actual, loaded = value, false
 	}
 	m.mu.Unlock()
@@ -249,6 +294,7 @@ func (e *entry) tryLoadOrStore(i interface{}) (actual interface{}, loaded, ok bo
 	ic := i
 	for {
 		if atomic.CompareAndSwapPointer(&e.p, nil, unsafe.Pointer(&ic)) {
+			atomic.AddInt64(e.count, 1)
 			return i, false, true
 		}
 		p = atomic.LoadPointer(&e.p)
@@ -267,6 +313,14 @@ func (e *entry) tryLoadOrStore(i interface{}) (actual interface{}, loaded, ok bo
 // use the recalculated value obtained by remappingFunction and save it as the value of the key,
 // otherwise return the value.
 func (m *Map) ComputeIfAbsent(key interface{}, computeFunc func(key interface{}) interface{}) (actual interface{}, loaded bool) {
+	actual, loaded = m.computeIfAbsent(key, computeFunc)
+	if !loaded {
+		m.watchers.notify(EventStore, key, actual)
+	}
+	return actual, loaded
+}
+
+func (m *Map) computeIfAbsent(key interface{}, computeFunc func(key interface{}) interface{}) (actual interface{}, loaded bool) {
 	read, _ := m.read.Load().(readOnly)
 	if e, ok := read.m[key]; ok {
 		actual, loaded, ok := e.tryComputeIfAbsent(key, computeFunc)
@@ -275,6 +329,9 @@ func (m *Map) ComputeIfAbsent(key interface{}, computeFunc func(key interface{})
 		}
 	}
 	m.mu.Lock()
+	// computeFunc may panic.
+	defer m.mu.Unlock()
+
 	read, _ = m.read.Load().(readOnly)
 	if e, ok := read.m[key]; ok {
 		if e.unexpungeLocked() {
@@ -292,10 +349,9 @@ func (m *Map) ComputeIfAbsent(key interface{}, computeFunc func(key interface{})
 			m.read.Store(readOnly{m: read.m, amended: true})
 		}
 		value := computeFunc(key)
-		m.dirty[key] = newEntry(value)
+		m.dirty[key] = newEntry(value, m.countLocked())
 		actual, loaded = value, false
 	}
-	m.mu.Unlock()
 	return actual, loaded
 }
 
@@ -315,6 +371,7 @@ func (e *entry) tryComputeIfAbsent(key interface{}, computeFunc func(key interfa
 	ic := i
 	for {
 		if atomic.CompareAndSwapPointer(&e.p, nil, unsafe.Pointer(&ic)) {
+			atomic.AddInt64(e.count, 1)
 			return i, false, true
 		}
 		p = atomic.LoadPointer(&e.p)
@@ -332,6 +389,14 @@ func (e *entry) tryComputeIfAbsent(key interface{}, computeFunc func(key interfa
 // ComputeIfPresent if the value corresponding to the key does not exist,
 // the null is returned, and if it exists, the value recalculated by remappingFunction is returned.
 func (m *Map) ComputeIfPresent(key interface{}, computeFunc func(key, value interface{}) interface{}) (actual interface{}, exist bool) {
+	actual, exist = m.computeIfPresent(key, computeFunc)
+	if exist {
+		m.watchers.notify(EventStore, key, actual)
+	}
+	return actual, exist
+}
+
+func (m *Map) computeIfPresent(key interface{}, computeFunc func(key, value interface{}) interface{}) (actual interface{}, exist bool) {
 	read, _ := m.read.Load().(readOnly)
 	if e, ok := read.m[key]; ok {
 		actual, loaded, ok := e.tryComputeIfPresent(key, computeFunc)
@@ -340,6 +405,9 @@ func (m *Map) ComputeIfPresent(key interface{}, computeFunc func(key, value inte
 		}
 	}
 	m.mu.Lock()
+	// computeFunc may panic.
+	defer m.mu.Unlock()
+
 	read, _ = m.read.Load().(readOnly)
 	if e, ok := read.m[key]; ok {
 		if e.unexpungeLocked() {
@@ -352,7 +420,6 @@ func (m *Map) ComputeIfPresent(key interface{}, computeFunc func(key, value inte
 	} else {
 		actual, exist = nil, false
 	}
-	m.mu.Unlock()
 	return actual, exist
 }
 
@@ -384,9 +451,327 @@ func (e *entry) tryComputeIfPresent(key interface{}, computeFunc func(key, value
 
 // ---------------------------
 
+// Compute atomically computes a new value for the key with computeFunc, which receives the
+// current value and whether it exists.
+// If keep is false, the key is deleted, otherwise newValue is stored.
+// The ok result reports whether the key is present after the call.
+//
+// computeFunc may be called more than once if the value is changed concurrently.
+func (m *Map) Compute(key interface{}, computeFunc func(oldValue interface{}, exists bool) (newValue interface{}, keep bool)) (actual interface{}, ok bool) {
+	if !m.watchers.watched() {
+		return m.compute(key, computeFunc)
+	}
+
+	// The arguments of the last call of computeFunc are the ones the result was computed from.
+	var (
+		lastValue  interface{}
+		lastExists bool
+	)
+	actual, ok = m.compute(key, func(oldValue interface{}, exists bool) (interface{}, bool) {
+		lastValue, lastExists = oldValue, exists
+		return computeFunc(oldValue, exists)
+	})
+	if ok {
+		m.watchers.notify(EventStore, key, actual)
+	} else if lastExists {
+		m.watchers.notify(EventDelete, key, lastValue)
+	}
+	return actual, ok
+}
+
+func (m *Map) compute(key interface{}, computeFunc func(oldValue interface{}, exists bool) (newValue interface{}, keep bool)) (actual interface{}, ok bool) {
+	read, _ := m.read.Load().(readOnly)
+	if e, ok := read.m[key]; ok {
+		actual, ok, done := e.tryCompute(computeFunc)
+		if done {
+			return actual, ok
+		}
+	}
+
+	m.mu.Lock()
+	// computeFunc may panic.
+	defer m.mu.Unlock()
+
+	read, _ = m.read.Load().(readOnly)
+	if e, exist := read.m[key]; exist {
+		if e.unexpungeLocked() {
+			m.dirty[key] = e
+		}
+		actual, ok, _ = e.tryCompute(computeFunc)
+	} else if e, exist := m.dirty[key]; exist {
+		actual, ok, _ = e.tryCompute(computeFunc)
+		m.missLocked()
+	} else if value, keep := computeFunc(nil, false); keep {
+		if !read.amended {
+			// We're adding the first new key to the dirty map.
+			// Make sure it is allocated and mark the read-only map as incomplete.
+			m.dirtyLocked()
+			m.read.Store(readOnly{m: read.m, amended: true})
+		}
+		m.dirty[key] = newEntry(value, m.countLocked())
+		actual, ok = value, true
+	}
+	return actual, ok
+}
+
+// tryCompute computes the value of the entry if it has not been expunged.
+//
+// If the entry is expunged, tryCompute leaves the entry unchanged and
+// returns with done==false.
+func (e *entry) tryCompute(computeFunc func(oldValue interface{}, exists bool) (newValue interface{}, keep bool)) (actual interface{}, ok, done bool) {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == expunged {
+			return nil, false, false
+		}
+
+		var oldValue interface{}
+		exists := p != nil
+		if exists {
+			oldValue = *(*interface{})(p)
+		}
+
+		newValue, keep := computeFunc(oldValue, exists)
+		if !keep {
+			if !exists {
+				return nil, false, true
+			}
+			if atomic.CompareAndSwapPointer(&e.p, p, nil) {
+				atomic.AddInt64(e.count, -1)
+				return nil, false, true
+			}
+			continue
+		}
+
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(&newValue)) {
+			if !exists {
+				atomic.AddInt64(e.count, 1)
+			}
+			return newValue, true, true
+		}
+	}
+}
+
+// Merge stores value if the key is absent, otherwise stores the result of mergeFunc
+// called with the current value and value.
+// If mergeFunc returns nil, the key is deleted.
+// The ok result reports whether the key is present after the call.
+func (m *Map) Merge(key, value interface{}, mergeFunc func(oldValue, value interface{}) interface{}) (actual interface{}, ok bool) {
+	return m.Compute(key, func(oldValue interface{}, exists bool) (interface{}, bool) {
+		if !exists {
+			return value, true
+		}
+
+		newValue := mergeFunc(oldValue, value)
+		return newValue, newValue != nil
+	})
+}
+
+// ---------------------------
+
+// Swap swaps the value for a key and returns the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *Map) Swap(key, value interface{}) (previous interface{}, loaded bool) {
+	previous, loaded = m.swap(key, value)
+	m.watchers.notify(EventStore, key, value)
+	return previous, loaded
+}
+
+func (m *Map) swap(key, value interface{}) (previous interface{}, loaded bool) {
+	read, _ := m.read.Load().(readOnly)
+	if e, ok := read.m[key]; ok {
+		if v, ok := e.trySwap(&value); ok {
+			if v == nil {
+				return nil, false
+			}
+			return *v, true
+		}
+	}
+
+	m.mu.Lock()
+	read, _ = m.read.Load().(readOnly)
+	if e, ok := read.m[key]; ok {
+		if e.unexpungeLocked() {
+			// The entry was previously expunged, which implies that there is a
+			// non-nil dirty map and this entry is not in it.
+			m.dirty[key] = e
+		}
+		if v := e.swapLocked(&value); v != nil {
+			previous, loaded = *v, true
+		}
+	} else if e, ok := m.dirty[key]; ok {
+		if v := e.swapLocked(&value); v != nil {
+			previous, loaded = *v, true
+		}
+	} else {
+		if !read.amended {
+			// We're adding the first new key to the dirty map.
+			// Make sure it is allocated and mark the read-only map as incomplete.
+			m.dirtyLocked()
+			m.read.Store(readOnly{m: read.m, amended: true})
+		}
+		m.dirty[key] = newEntry(value, m.countLocked())
+	}
+	m.mu.Unlock()
+	return previous, loaded
+}
+
+// trySwap swaps a value if the entry has not been expunged.
+//
+// If the entry is expunged, trySwap returns false and leaves the entry
+// unchanged.
+func (e *entry) trySwap(i *interface{}) (*interface{}, bool) {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == expunged {
+			return nil, false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(i)) {
+			if p == nil {
+				atomic.AddInt64(e.count, 1)
+			}
+			return (*interface{})(p), true
+		}
+	}
+}
+
+// swapLocked unconditionally swaps a value into the entry.
+//
+// The entry must be known not to be expunged.
+func (e *entry) swapLocked(i *interface{}) *interface{} {
+	p := atomic.SwapPointer(&e.p, unsafe.Pointer(i))
+	if p == nil {
+		atomic.AddInt64(e.count, 1)
+	}
+	return (*interface{})(p)
+}
+
+// CompareAndSwap swaps the old and new values for key
+// if the value stored in the map is equal to old.
+// The old value must be of a comparable type.
+func (m *Map) CompareAndSwap(key, old, new interface{}) bool {
+	if !m.compareAndSwap(key, old, new) {
+		return false
+	}
+
+	m.watchers.notify(EventStore, key, new)
+	return true
+}
+
+func (m *Map) compareAndSwap(key, old, new interface{}) bool {
+	read, _ := m.read.Load().(readOnly)
+	if e, ok := read.m[key]; ok {
+		return e.tryCompareAndSwap(old, new)
+	} else if !read.amended {
+		return false // No existing value for key.
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	read, _ = m.read.Load().(readOnly)
+	swapped := false
+	if e, ok := read.m[key]; ok {
+		swapped = e.tryCompareAndSwap(old, new)
+	} else if e, ok := m.dirty[key]; ok {
+		swapped = e.tryCompareAndSwap(old, new)
+		// We needed to lock mu in order to load the entry for key,
+		// and the operation didn't change the set of keys in the map
+		// (so it would be made more efficient by promoting the dirty
+		// map to read-only).
+		// Count it as a miss so that we will eventually switch to the
+		// more efficient steady state.
+		m.missLocked()
+	}
+	return swapped
+}
+
+// tryCompareAndSwap compare the entry with the given old value and swaps
+// it with a new value if the entry is equal to the old value, and the entry
+// has not been expunged.
+//
+// If the entry is expunged, tryCompareAndSwap returns false and leaves
+// the entry unchanged.
+func (e *entry) tryCompareAndSwap(old, new interface{}) bool {
+	p := atomic.LoadPointer(&e.p)
+	if p == nil || p == expunged || *(*interface{})(p) != old {
+		return false
+	}
+
+	// Copy the interface after the first load to make this method more amenable
+	// to escape analysis: if the comparison fails from the start, we shouldn't
+	// bother heap-allocating an interface value to store.
+	nc := new
+	for {
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(&nc)) {
+			return true
+		}
+		p = atomic.LoadPointer(&e.p)
+		if p == nil || p == expunged || *(*interface{})(p) != old {
+			return false
+		}
+	}
+}
+
+// CompareAndDelete deletes the entry for key if its value is equal to old.
+// The old value must be of a comparable type.
+//
+// If there is no current value for key in the map, CompareAndDelete
+// returns false (even if the old value is the nil interface value).
+func (m *Map) CompareAndDelete(key, old interface{}) (deleted bool) {
+	if !m.compareAndDelete(key, old) {
+		return false
+	}
+
+	m.watchers.notify(EventDelete, key, old)
+	return true
+}
+
+func (m *Map) compareAndDelete(key, old interface{}) (deleted bool) {
+	read, _ := m.read.Load().(readOnly)
+	e, ok := read.m[key]
+	if !ok && read.amended {
+		m.mu.Lock()
+		read, _ = m.read.Load().(readOnly)
+		e, ok = read.m[key]
+		if !ok && read.amended {
+			e, ok = m.dirty[key]
+			// Don't delete key from m.dirty: we still need to do the “compare” part
+			// of the operation. The entry will eventually be expunged when the
+			// dirty map is promoted to the read map.
+			//
+			// Regardless of whether the entry was present, record a miss: this key
+			// will take the slow path until the dirty map is promoted to the read
+			// map.
+			m.missLocked()
+		}
+		m.mu.Unlock()
+	}
+	for ok {
+		p := atomic.LoadPointer(&e.p)
+		if p == nil || p == expunged || *(*interface{})(p) != old {
+			return false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
+			atomic.AddInt64(e.count, -1)
+			return true
+		}
+	}
+	return false
+}
+
+// ---------------------------
+
 // LoadAndDelete deletes the value for a key, returning the previous value if any.
 // The loaded result reports whether the key was present.
 func (m *Map) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	value, loaded = m.loadAndDelete(key)
+	if loaded {
+		m.watchers.notify(EventDelete, key, value)
+	}
+	return value, loaded
+}
+
+func (m *Map) loadAndDelete(key interface{}) (value interface{}, loaded bool) {
 	read, _ := m.read.Load().(readOnly)
 	e, ok := read.m[key]
 	if !ok && read.amended {
@@ -416,12 +801,56 @@ func (m *Map) Delete(key interface{}) {
 
 // Clear clears all elements.
 func (m *Map) Clear() {
+	m.clear()
+	m.watchers.notify(EventClear, nil, nil)
+}
+
+func (m *Map) clear() {
 	m.mu.Lock()
-	m.read = atomic.Value{}
+	m.read.Store(readOnly{})
 	m.dirty = nil
 	m.misses = 0
+	atomic.StorePointer(&m.count, unsafe.Pointer(new(int64)))
 	m.mu.Unlock()
+}
+
+// Len returns the number of elements in the map.
+//
+// Len is O(1). Under concurrent writes it reflects the state of the map at some
+// point during the call, writes in progress may or may not be counted.
+func (m *Map) Len() int {
+	count := (*int64)(atomic.LoadPointer(&m.count))
+	if count == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(count))
+}
 
+// Keys returns all keys present in the map.
+//
+// Keys has the same consistency as Range: no key is returned more than once,
+// but keys stored or deleted concurrently may or may not be returned.
+func (m *Map) Keys() []interface{} {
+	keys := make([]interface{}, 0, m.Len())
+	m.Range(func(key, value interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Snapshot returns a copy of all keys and values present in the map.
+//
+// Snapshot has the same consistency as Range: it doesn't necessarily correspond to
+// any consistent state of the map, the value of a key written concurrently may be
+// any value of the key during the call.
+func (m *Map) Snapshot() map[interface{}]interface{} {
+	snapshot := make(map[interface{}]interface{}, m.Len())
+	m.Range(func(key, value interface{}) bool {
+		snapshot[key] = value
+		return true
+	})
+	return snapshot
 }
 
 func (e *entry) delete() (value interface{}, ok bool) {
@@ -431,6 +860,7 @@ func (e *entry) delete() (value interface{}, ok bool) {
 			return nil, false
 		}
 		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
+			atomic.AddInt64(e.count, -1)
 			return *(*interface{})(p), true
 		}
 	}
@@ -513,3 +943,115 @@ func (e *entry) tryExpungeLocked() (isExpunged bool) {
 	}
 	return p == expunged
 }
+
+// ---------------------------
+
+// loadAll calls found with the value of every key present in the map,
+// the keys which need mu are looked up while locking it once.
+func (m *Map) loadAll(keys []string, found func(key string, value interface{})) {
+	read, _ := m.read.Load().(readOnly)
+	var slow []string
+	for _, key := range keys {
+		if e, ok := read.m[key]; ok {
+			if value, ok := e.load(); ok {
+				found(key, value)
+			}
+		} else if read.amended {
+			slow = append(slow, key)
+		}
+	}
+	if len(slow) == 0 {
+		return
+	}
+
+	entries := make([]*entry, len(slow))
+	m.mu.Lock()
+	for i, key := range slow {
+		read, _ = m.read.Load().(readOnly)
+		e, ok := read.m[key]
+		if !ok && read.amended {
+			e = m.dirty[key]
+			m.missLocked()
+		}
+		entries[i] = e
+	}
+	m.mu.Unlock()
+
+	for i, e := range entries {
+		if e == nil {
+			continue
+		}
+		if value, ok := e.load(); ok {
+			found(slow[i], value)
+		}
+	}
+}
+
+// storeAll stores the values of keys given by data, the keys which need mu are stored while locking it once.
+func (m *Map) storeAll(keys []string, data map[string]interface{}) {
+	read, _ := m.read.Load().(readOnly)
+	var slow []string
+	for _, key := range keys {
+		value := data[key]
+		if e, ok := read.m[key]; !ok || !e.tryStore(&value) {
+			slow = append(slow, key)
+		}
+	}
+	if len(slow) > 0 {
+		m.mu.Lock()
+		for _, key := range slow {
+			m.storeSlowLocked(key, data[key])
+		}
+		m.mu.Unlock()
+	}
+
+	if m.watchers.watched() {
+		for _, key := range keys {
+			m.watchers.notify(EventStore, key, data[key])
+		}
+	}
+}
+
+// loadAndDeleteAll deletes keys and calls deleted with the value of every deleted key,
+// the keys which need mu are deleted while locking it once.
+func (m *Map) loadAndDeleteAll(keys []string, deleted func(key string, value interface{})) {
+	remove := func(key string, e *entry) {
+		if value, ok := e.delete(); ok {
+			m.watchers.notify(EventDelete, key, value)
+			deleted(key, value)
+		}
+	}
+
+	read, _ := m.read.Load().(readOnly)
+	var slow []string
+	for _, key := range keys {
+		if e, ok := read.m[key]; ok {
+			remove(key, e)
+		} else if read.amended {
+			slow = append(slow, key)
+		}
+	}
+	if len(slow) == 0 {
+		return
+	}
+
+	entries := make([]*entry, len(slow))
+	m.mu.Lock()
+	for i, key := range slow {
+		read, _ = m.read.Load().(readOnly)
+		e, ok := read.m[key]
+		if !ok && read.amended {
+			e = m.dirty[key]
+			delete(m.dirty, key)
+			m.missLocked()
+		}
+		entries[i] = e
+	}
+	m.mu.Unlock()
+
+	for i, e := range entries {
+		if e != nil {
+			remove(slow[i], e)
+		}
+	}
+}
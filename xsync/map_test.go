@@ -24,6 +24,8 @@ import (
 	"sync/atomic"
 	"testing"
 	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
 )
 
 type mapOp string
@@ -219,3 +221,223 @@ func TestIssue40999(t *testing.T) {
 		runtime.GC()
 	}
 }
+
+func TestMapCompute(t *testing.T) {
+	var m Map
+
+	actual, ok := m.Compute("a", func(oldValue interface{}, exists bool) (interface{}, bool) {
+		assert.False(t, exists)
+		return 1, true
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 1, actual)
+
+	actual, ok = m.Compute("a", func(oldValue interface{}, exists bool) (interface{}, bool) {
+		assert.True(t, exists)
+		return oldValue.(int) + 1, true
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 2, actual)
+
+	actual, ok = m.Compute("a", func(oldValue interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+	assert.False(t, ok)
+	assert.Nil(t, actual)
+	_, ok = m.Load("a")
+	assert.False(t, ok)
+
+	_, ok = m.Compute("b", func(oldValue interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+	assert.False(t, ok)
+	_, ok = m.Load("b")
+	assert.False(t, ok)
+}
+
+func TestMapComputeConcurrent(t *testing.T) {
+	var m Map
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 1000; j++ {
+				m.Compute("counter", func(oldValue interface{}, exists bool) (interface{}, bool) {
+					if !exists {
+						return 1, true
+					}
+					return oldValue.(int) + 1, true
+				})
+				m.Merge(j%10, 1, func(oldValue, value interface{}) interface{} {
+					return oldValue.(int) + value.(int)
+				})
+			}
+		}()
+	}
+	wait.Wait()
+
+	counter, _ := m.Load("counter")
+	assert.Equal(t, 8000, counter)
+	for i := 0; i < 10; i++ {
+		v, _ := m.Load(i)
+		assert.Equal(t, 800, v)
+	}
+}
+
+func TestMapMerge(t *testing.T) {
+	var m Map
+	union := func(oldValue, value interface{}) interface{} {
+		return append(oldValue.([]string), value.([]string)...)
+	}
+
+	actual, ok := m.Merge("set", []string{"a"}, union)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a"}, actual)
+
+	actual, ok = m.Merge("set", []string{"b"}, union)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"a", "b"}, actual)
+
+	actual, ok = m.Merge("set", nil, func(oldValue, value interface{}) interface{} {
+		return nil
+	})
+	assert.False(t, ok)
+	assert.Nil(t, actual)
+	_, ok = m.Load("set")
+	assert.False(t, ok)
+}
+
+func TestMapSwap(t *testing.T) {
+	var m Map
+
+	previous, loaded := m.Swap("a", 1)
+	assert.False(t, loaded)
+	assert.Nil(t, previous)
+
+	previous, loaded = m.Swap("a", 2)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, previous)
+
+	// Promote the dirty map, so the next swap takes the fast path.
+	m.Range(func(key, value interface{}) bool {
+		return true
+	})
+	previous, loaded = m.Swap("a", 3)
+	assert.True(t, loaded)
+	assert.Equal(t, 2, previous)
+
+	m.Delete("a")
+	previous, loaded = m.Swap("a", 4)
+	assert.False(t, loaded)
+	assert.Nil(t, previous)
+}
+
+func TestMapCompareAndSwap(t *testing.T) {
+	var m Map
+
+	assert.False(t, m.CompareAndSwap("a", nil, 1))
+	m.Store("a", 1)
+	assert.False(t, m.CompareAndSwap("a", 2, 3))
+	assert.True(t, m.CompareAndSwap("a", 1, 2))
+	v, _ := m.Load("a")
+	assert.Equal(t, 2, v)
+
+	m.Range(func(key, value interface{}) bool {
+		return true
+	})
+	assert.True(t, m.CompareAndSwap("a", 2, 3))
+	v, _ = m.Load("a")
+	assert.Equal(t, 3, v)
+}
+
+func TestMapCompareAndDelete(t *testing.T) {
+	var m Map
+
+	assert.False(t, m.CompareAndDelete("a", nil))
+	m.Store("a", 1)
+	assert.False(t, m.CompareAndDelete("a", 2))
+	assert.True(t, m.CompareAndDelete("a", 1))
+	_, ok := m.Load("a")
+	assert.False(t, ok)
+	assert.False(t, m.CompareAndDelete("a", 1))
+}
+
+func TestMapLen(t *testing.T) {
+	var m Map
+	assert.Equal(t, 0, m.Len())
+
+	for i := 0; i < 10; i++ {
+		m.Store(i, i)
+	}
+	m.Store(0, 0)
+	m.LoadOrStore(10, 10)
+	m.LoadOrStore(10, 11)
+	m.ComputeIfAbsent(11, func(key interface{}) interface{} {
+		return key
+	})
+	m.ComputeIfPresent(11, func(key, value interface{}) interface{} {
+		return value
+	})
+	m.Swap(12, 12)
+	assert.Equal(t, 13, m.Len())
+
+	m.Delete(0)
+	m.Delete(0)
+	m.LoadAndDelete(1)
+	m.CompareAndDelete(2, 2)
+	m.Compute(3, func(oldValue interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+	assert.Equal(t, 9, m.Len())
+
+	// Reuse deleted entries.
+	m.Store(0, 0)
+	m.Merge(1, 1, func(oldValue, value interface{}) interface{} {
+		return value
+	})
+	m.Range(func(key, value interface{}) bool {
+		return true
+	})
+	m.Store(2, 2)
+	assert.Equal(t, 12, m.Len())
+	assert.Len(t, m.Keys(), 12)
+
+	m.Clear()
+	assert.Equal(t, 0, m.Len())
+	m.Store(0, 0)
+	assert.Equal(t, 1, m.Len())
+}
+
+func TestMapLenConcurrent(t *testing.T) {
+	var m Map
+	var wait sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wait.Add(1)
+		go func(g int) {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				m.Store(i, g)
+				if i%2 == 0 {
+					m.Delete(i)
+				}
+			}
+		}(g)
+	}
+	wait.Wait()
+
+	assert.Equal(t, len(m.Snapshot()), m.Len())
+}
+
+func TestMapSnapshot(t *testing.T) {
+	var m Map
+	m.Store("a", 1)
+	m.Store("b", 2)
+
+	snapshot := m.Snapshot()
+	assert.Equal(t, map[interface{}]interface{}{"a": 1, "b": 2}, snapshot)
+
+	m.Store("c", 3)
+	assert.Len(t, snapshot, 2)
+	assert.ElementsMatch(t, []interface{}{"a", "b", "c"}, m.Keys())
+}
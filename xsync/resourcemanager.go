@@ -17,76 +17,518 @@
 package xsync
 
 import (
+	"context"
+	"errors"
 	"github.com/chenquan/go-pkg/xerror"
 	"golang.org/x/sync/singleflight"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// ResourceManager is a resource manager.
-type ResourceManager struct {
-	rw           sync.RWMutex
-	resources    map[string]io.Closer
-	singleFlight singleflight.Group
+var (
+	// ErrClosed is returned when a ResourceManager is used after Close.
+	ErrClosed = errors.New("resource manager closed")
+)
+
+type (
+	// ResourceManager is a resource manager.
+	//
+	// Resources obtained by Acquire are reference counted, a resource removed from the manager
+	// is closed once all its handles are released. Resources obtained by Get are not counted.
+	ResourceManager struct {
+		rw           sync.RWMutex
+		resources    map[string]*managedResource
+		singleFlight singleflight.Group
+		// invalidated holds the keys whose resource was invalidated and not recreated yet.
+		invalidated map[string]struct{}
+		closed      bool
+		// ctx is cancelled by Close, it cancels the creations in progress.
+		ctx    context.Context
+		cancel context.CancelFunc
+		// creations holds the creations in progress by key, guarded by creationLock.
+		creations    map[string]*creation
+		creationLock sync.Mutex
+
+		idleTimeout         time.Duration
+		healthCheckInterval time.Duration
+		hooks               ResourceHooks
+		done                chan struct{}
+		stop                sync.Once
+		running             sync.WaitGroup
+	}
+
+	// ResourceHandle is a reference to a resource of a ResourceManager,
+	// it must be released after use.
+	ResourceHandle struct {
+		m        *ResourceManager
+		resource *managedResource
+		once     sync.Once
+	}
+
+	// HealthChecker is implemented by the resources which can be probed by a ResourceManager,
+	// see WithHealthCheck.
+	HealthChecker interface {
+		// HealthCheck returns an error if the resource is broken.
+		HealthCheck() error
+	}
+
+	// ResourceHooks are called on the events of the resources of a ResourceManager.
+	// Every hook is optional, they are called synchronously and must not block.
+	ResourceHooks struct {
+		// OnCreate is called after a resource was created.
+		OnCreate func(key string, resource io.Closer)
+		// OnRecreate is called after the resource of an invalidated key was created.
+		OnRecreate func(key string, resource io.Closer)
+		// OnFailure is called when a resource fails to be created, to pass a health check or to be closed.
+		OnFailure func(key string, err error)
+	}
+
+	// ResourceManagerOption customizes a ResourceManager.
+	ResourceManagerOption func(*resourceManagerOptions)
+
+	resourceManagerOptions struct {
+		idleTimeout         time.Duration
+		healthCheckInterval time.Duration
+		hooks               ResourceHooks
+	}
+
+	managedResource struct {
+		key    string
+		closer io.Closer
+		// lastUsed is the UnixNano time of the last Get, Acquire or Release.
+		lastUsed int64
+
+		// The fields below are guarded by the lock of the ResourceManager.
+		refs int
+		// removed reports whether the resource was removed from the manager,
+		// it's closed once refs reaches 0.
+		removed bool
+		closed  bool
+	}
+
+	// creation is a creation in progress, it's cancelled once all the callers waiting for it gave up.
+	creation struct {
+		ctx     context.Context
+		cancel  context.CancelFunc
+		waiters int
+	}
+
+	// creationContext carries the values of the caller which started a creation,
+	// and is cancelled like the context of its creation.
+	creationContext struct {
+		context.Context
+		values context.Context
+	}
+)
+
+// WithIdleTimeout returns a ResourceManagerOption that closes and removes the resources
+// which are not referenced by any ResourceHandle and weren't used for timeout.
+// The manager checks the resources every timeout.
+func WithIdleTimeout(timeout time.Duration) ResourceManagerOption {
+	return func(o *resourceManagerOptions) {
+		o.idleTimeout = timeout
+	}
+}
+
+// WithHealthCheck returns a ResourceManagerOption that probes the resources implementing
+// HealthChecker every interval, and invalidates the broken ones.
+func WithHealthCheck(interval time.Duration) ResourceManagerOption {
+	return func(o *resourceManagerOptions) {
+		o.healthCheckInterval = interval
+	}
+}
+
+// WithResourceHooks returns a ResourceManagerOption that sets the hooks called on the events of the resources.
+func WithResourceHooks(hooks ResourceHooks) ResourceManagerOption {
+	return func(o *resourceManagerOptions) {
+		o.hooks = hooks
+	}
 }
 
 // NewResourceManager returns a ResourceManager.
-func NewResourceManager() *ResourceManager {
-	return &ResourceManager{resources: map[string]io.Closer{}}
+func NewResourceManager(opts ...ResourceManagerOption) *ResourceManager {
+	o := new(resourceManagerOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &ResourceManager{
+		ctx:                 ctx,
+		cancel:              cancel,
+		resources:           map[string]*managedResource{},
+		creations:           map[string]*creation{},
+		invalidated:         map[string]struct{}{},
+		idleTimeout:         o.idleTimeout,
+		healthCheckInterval: o.healthCheckInterval,
+		hooks:               o.hooks,
+		done:                make(chan struct{}),
+	}
+	if o.idleTimeout > 0 {
+		m.running.Add(1)
+		go m.every(o.idleTimeout, m.removeIdle)
+	}
+	if o.healthCheckInterval > 0 {
+		m.running.Add(1)
+		go m.every(o.healthCheckInterval, m.checkHealth)
+	}
+	return m
 }
 
-// Close the manager.
-// Don't use the ResourceManager after Close() called.
+// Close closes the manager and all its resources concurrently, even the ones referenced by handles,
+// and returns the errors of closing them.
+// The creations in progress are cancelled, the manager returns ErrClosed afterwards.
 func (m *ResourceManager) Close() error {
-	m.rw.Lock()
+	m.stop.Do(func() {
+		close(m.done)
+		m.cancel()
+	})
+	m.running.Wait()
 
-	var be xerror.BatchError
-	for _, resource := range m.resources {
-		if err := resource.Close(); err != nil {
-			be.Add(err)
-		}
-	}
+	m.rw.Lock()
+	resources := m.resources
 	m.resources = nil
-
+	m.closed = true
+	for _, resource := range resources {
+		resource.removed = true
+		resource.closed = true
+	}
 	m.rw.Unlock()
+
+	var (
+		be   xerror.BatchError
+		lock sync.Mutex
+		wait sync.WaitGroup
+	)
+	for _, resource := range resources {
+		wait.Add(1)
+		go func(resource *managedResource) {
+			defer wait.Done()
+			if err := resource.closer.Close(); err != nil {
+				lock.Lock()
+				be.Add(err)
+				lock.Unlock()
+			}
+		}(resource)
+	}
+	wait.Wait()
 	return be.Err()
 }
 
 // Get returns the resource associated with given key.
+//
+// The resource isn't reference counted, it may be closed by Remove, Invalidate or the background
+// checks while it's still used. Use Acquire to keep it open.
 func (m *ResourceManager) Get(key string, create func() (io.Closer, error)) (io.Closer, error) {
-	val, err, _ := m.singleFlight.Do(key, func() (interface{}, error) {
+	return m.GetContext(context.Background(), key, withoutContext(create))
+}
 
-		m.rw.RLock()
-		resource, ok := m.resources[key]
-		m.rw.RUnlock()
-		if ok {
-			return resource, nil
-		}
+// GetContext returns the resource associated with given key like Get,
+// and returns ctx.Err() if ctx is done before the resource is created.
+//
+// Concurrent callers of a key wait for the same creation, which goes on while any of them waits.
+// create is called with a context carrying the values of ctx, which is cancelled once all
+// the callers gave up or when the manager is closed.
+func (m *ResourceManager) GetContext(ctx context.Context, key string, create func(ctx context.Context) (io.Closer, error)) (io.Closer, error) {
+	resource, err := m.get(ctx, key, create)
+	if err != nil {
+		return nil, err
+	}
 
-		resource, err := create()
+	return resource.closer, nil
+}
+
+// Acquire returns a handle to the resource associated with given key,
+// the resource stays open until the handle is released.
+func (m *ResourceManager) Acquire(key string, create func() (io.Closer, error)) (*ResourceHandle, error) {
+	return m.AcquireContext(context.Background(), key, withoutContext(create))
+}
+
+// AcquireContext returns a handle to the resource associated with given key like Acquire,
+// the creation of the resource is handled like in GetContext.
+func (m *ResourceManager) AcquireContext(ctx context.Context, key string, create func(ctx context.Context) (io.Closer, error)) (*ResourceHandle, error) {
+	for {
+		resource, err := m.get(ctx, key, create)
 		if err != nil {
 			return nil, err
 		}
 
 		m.rw.Lock()
-		m.resources[key] = resource
+		if m.closed {
+			m.rw.Unlock()
+			return nil, ErrClosed
+		}
+		if m.resources[key] == resource {
+			resource.refs++
+			m.rw.Unlock()
+			return &ResourceHandle{m: m, resource: resource}, nil
+		}
 		m.rw.Unlock()
+		// The resource was removed meanwhile, get the next one.
+	}
+}
 
+func withoutContext(create func() (io.Closer, error)) func(ctx context.Context) (io.Closer, error) {
+	return func(context.Context) (io.Closer, error) {
+		return create()
+	}
+}
+
+func (m *ResourceManager) get(ctx context.Context, key string, create func(ctx context.Context) (io.Closer, error)) (*managedResource, error) {
+	m.rw.RLock()
+	resource, ok := m.resources[key]
+	closed := m.closed
+	m.rw.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if ok {
+		resource.touch()
 		return resource, nil
+	}
+
+	c := m.wait(key)
+	defer m.leave(key, c)
+	result := m.singleFlight.DoChan(key, func() (interface{}, error) {
+		return m.create(creationContext{Context: c.ctx, values: ctx}, key, create)
 	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-result:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+
+		resource := r.Val.(*managedResource)
+		resource.touch()
+		return resource, nil
+	}
+}
+
+// wait registers a caller waiting for the creation of key, and returns the creation.
+func (m *ResourceManager) wait(key string) *creation {
+	m.creationLock.Lock()
+	defer m.creationLock.Unlock()
+
+	c, ok := m.creations[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(m.ctx)
+		c = &creation{ctx: ctx, cancel: cancel}
+		m.creations[key] = c
+	}
+	c.waiters++
+	return c
+}
+
+// leave unregisters a caller of the creation c of key, the last one cancels the creation
+// and lets the next callers start a new one.
+func (m *ResourceManager) leave(key string, c *creation) {
+	m.creationLock.Lock()
+	defer m.creationLock.Unlock()
+
+	c.waiters--
+	if c.waiters > 0 {
+		return
+	}
+
+	delete(m.creations, key)
+	m.singleFlight.Forget(key)
+	c.cancel()
+}
+
+func (m *ResourceManager) create(ctx context.Context, key string, create func(ctx context.Context) (io.Closer, error)) (*managedResource, error) {
+	m.rw.RLock()
+	resource, ok := m.resources[key]
+	closed := m.closed
+	m.rw.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if ok {
+		return resource, nil
+	}
+
+	closer, err := create(ctx)
 	if err != nil {
+		m.fail(key, err)
 		return nil, err
 	}
 
-	return val.(io.Closer), nil
+	resource = &managedResource{key: key, closer: closer, lastUsed: time.Now().UnixNano()}
+	m.rw.Lock()
+	if m.closed {
+		m.rw.Unlock()
+		// The manager was closed during the creation.
+		resource.closed = true
+		m.closeResource(resource)
+		return nil, ErrClosed
+	}
+	if existing, ok := m.resources[key]; ok {
+		m.rw.Unlock()
+		// A creation given up by its callers completed after the next one.
+		resource.closed = true
+		m.closeResource(resource)
+		return existing, nil
+	}
+	m.resources[key] = resource
+	_, recreated := m.invalidated[key]
+	delete(m.invalidated, key)
+	m.rw.Unlock()
+
+	if recreated {
+		if m.hooks.OnRecreate != nil {
+			m.hooks.OnRecreate(key, closer)
+		}
+	} else if m.hooks.OnCreate != nil {
+		m.hooks.OnCreate(key, closer)
+	}
+	return resource, nil
 }
 
 // Remove the resource associated with given key and return it if existed.
+// The resource is closed once all its handles are released.
 func (m *ResourceManager) Remove(key string) (exist bool) {
+	return m.remove(key, nil, false)
+}
+
+// Invalidate removes the resource associated with given key like Remove,
+// the next Get or Acquire of the key recreates it.
+func (m *ResourceManager) Invalidate(key string) (exist bool) {
+	return m.remove(key, nil, true)
+}
+
+// remove removes the resource of key if it's expected, or any resource if expected is nil.
+func (m *ResourceManager) remove(key string, expected *managedResource, invalidate bool) bool {
 	m.rw.Lock()
-	if _, exist = m.resources[key]; exist {
-		delete(m.resources, key)
+	resource, exist := m.resources[key]
+	if !exist || expected != nil && resource != expected {
+		m.rw.Unlock()
+		return false
+	}
+
+	delete(m.resources, key)
+	resource.removed = true
+	if invalidate {
+		m.invalidated[key] = struct{}{}
 	}
+	shouldClose := resource.closeableLocked()
 	m.rw.Unlock()
+
+	if shouldClose {
+		m.closeResource(resource)
+	}
+	return true
+}
+
+func (m *ResourceManager) every(interval time.Duration, fn func()) {
+	defer m.running.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
+
+func (m *ResourceManager) removeIdle() {
+	deadline := time.Now().Add(-m.idleTimeout).UnixNano()
+
+	var idle []*managedResource
+	m.rw.Lock()
+	for key, resource := range m.resources {
+		if resource.refs == 0 && atomic.LoadInt64(&resource.lastUsed) <= deadline {
+			delete(m.resources, key)
+			resource.removed = true
+			if resource.closeableLocked() {
+				idle = append(idle, resource)
+			}
+		}
+	}
+	m.rw.Unlock()
+
+	for _, resource := range idle {
+		m.closeResource(resource)
+	}
+}
+
+func (m *ResourceManager) checkHealth() {
+	var checked []*managedResource
+	m.rw.RLock()
+	for _, resource := range m.resources {
+		if _, ok := resource.closer.(HealthChecker); ok {
+			checked = append(checked, resource)
+		}
+	}
+	m.rw.RUnlock()
+
+	for _, resource := range checked {
+		if err := resource.closer.(HealthChecker).HealthCheck(); err != nil {
+			m.fail(resource.key, err)
+			// The resource may have been replaced meanwhile.
+			m.remove(resource.key, resource, true)
+		}
+	}
+}
+
+func (m *ResourceManager) closeResource(resource *managedResource) {
+	if err := resource.closer.Close(); err != nil {
+		m.fail(resource.key, err)
+	}
+}
+
+func (m *ResourceManager) fail(key string, err error) {
+	if m.hooks.OnFailure != nil {
+		m.hooks.OnFailure(key, err)
+	}
+}
+
+// Resource returns the resource.
+func (h *ResourceHandle) Resource() io.Closer {
+	return h.resource.closer
+}
+
+// Release releases the handle, and closes the resource if it was the last handle of a removed resource.
+// It returns the error of closing the resource. Release can be called more than once.
+func (h *ResourceHandle) Release() (err error) {
+	h.once.Do(func() {
+		h.m.rw.Lock()
+		h.resource.refs--
+		h.resource.touch()
+		shouldClose := h.resource.closeableLocked()
+		h.m.rw.Unlock()
+
+		if shouldClose {
+			if err = h.resource.closer.Close(); err != nil {
+				h.m.fail(h.resource.key, err)
+			}
+		}
+	})
 	return
 }
+
+func (r *managedResource) touch() {
+	atomic.StoreInt64(&r.lastUsed, time.Now().UnixNano())
+}
+
+// closeableLocked reports whether r must be closed now, and marks it closed if so.
+func (r *managedResource) closeableLocked() bool {
+	if !r.removed || r.refs > 0 || r.closed {
+		return false
+	}
+
+	r.closed = true
+	return true
+}
+
+// Value returns the value of the context of the caller.
+func (c creationContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
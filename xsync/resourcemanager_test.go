@@ -17,10 +17,15 @@
 package xsync
 
 import (
+	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
 	"io"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type dummyResource struct {
@@ -101,3 +106,408 @@ func TestResourceManager_Remove(t *testing.T) {
 	assert.NotNil(t, closer)
 	assert.True(t, manager.Remove("key"))
 }
+
+type countingResource struct {
+	closed int32
+}
+
+func (cr *countingResource) Close() error {
+	atomic.AddInt32(&cr.closed, 1)
+	return nil
+}
+
+func (cr *countingResource) closedTimes() int32 {
+	return atomic.LoadInt32(&cr.closed)
+}
+
+func TestResourceManager_Acquire(t *testing.T) {
+	manager := NewResourceManager()
+	defer func() {
+		_ = manager.Close()
+	}()
+
+	resource := &countingResource{}
+	create := func() (io.Closer, error) {
+		return resource, nil
+	}
+	first, err := manager.Acquire("key", create)
+	assert.NoError(t, err)
+	second, err := manager.Acquire("key", create)
+	assert.NoError(t, err)
+	assert.Equal(t, resource, first.Resource())
+	assert.Equal(t, resource, second.Resource())
+
+	assert.True(t, manager.Remove("key"))
+	assert.NoError(t, first.Release())
+	assert.NoError(t, first.Release())
+	assert.Equal(t, int32(0), resource.closedTimes())
+	assert.NoError(t, second.Release())
+	assert.Equal(t, int32(1), resource.closedTimes())
+
+	_, err = manager.Acquire("key", func() (io.Closer, error) {
+		return nil, errors.New("fail")
+	})
+	assert.EqualError(t, err, "fail")
+}
+
+func TestResourceManager_RemoveCloses(t *testing.T) {
+	manager := NewResourceManager()
+	resource := &countingResource{}
+	handle, err := manager.Acquire("key", func() (io.Closer, error) {
+		return resource, nil
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, handle.Release())
+
+	assert.True(t, manager.Remove("key"))
+	assert.False(t, manager.Remove("key"))
+	assert.Equal(t, int32(1), resource.closedTimes())
+	assert.NoError(t, manager.Close())
+	assert.Equal(t, int32(1), resource.closedTimes())
+}
+
+func TestResourceManager_IdleTimeout(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	manager := NewResourceManager(WithIdleTimeout(time.Millisecond * 10))
+	defer func() {
+		_ = manager.Close()
+	}()
+
+	idle := &countingResource{}
+	_, err := manager.Get("idle", func() (io.Closer, error) {
+		return idle, nil
+	})
+	assert.NoError(t, err)
+
+	busy := &countingResource{}
+	handle, err := manager.Acquire("busy", func() (io.Closer, error) {
+		return busy, nil
+	})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return idle.closedTimes() == 1
+	}, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(t, int32(0), busy.closedTimes())
+
+	assert.NoError(t, handle.Release())
+	assert.Eventually(t, func() bool {
+		return busy.closedTimes() == 1
+	}, time.Second, time.Millisecond)
+
+	recreated, err := manager.Get("idle", func() (io.Closer, error) {
+		return &countingResource{}, nil
+	})
+	assert.NoError(t, err)
+	assert.NotSame(t, idle, recreated)
+}
+
+func TestResourceManager_AcquireConcurrent(t *testing.T) {
+	manager := NewResourceManager(WithIdleTimeout(time.Millisecond))
+	defer func() {
+		_ = manager.Close()
+	}()
+
+	var (
+		wait      sync.WaitGroup
+		resources []*countingResource
+		lock      sync.Mutex
+	)
+	for g := 0; g < 8; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 100; i++ {
+				handle, err := manager.Acquire("key", func() (io.Closer, error) {
+					resource := &countingResource{}
+					lock.Lock()
+					resources = append(resources, resource)
+					lock.Unlock()
+					return resource, nil
+				})
+				assert.NoError(t, err)
+				assert.Equal(t, int32(0), handle.Resource().(*countingResource).closedTimes())
+				if i%10 == 0 {
+					manager.Remove("key")
+				}
+				assert.NoError(t, handle.Release())
+			}
+		}()
+	}
+	wait.Wait()
+
+	assert.NoError(t, manager.Close())
+	for _, resource := range resources {
+		assert.Equal(t, int32(1), resource.closedTimes())
+	}
+}
+
+type healthResource struct {
+	countingResource
+	healthy int32
+}
+
+func (hr *healthResource) HealthCheck() error {
+	if atomic.LoadInt32(&hr.healthy) == 1 {
+		return nil
+	}
+	return errors.New("unhealthy")
+}
+
+type hookRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (hr *hookRecorder) record(event string) {
+	hr.lock.Lock()
+	hr.events = append(hr.events, event)
+	hr.lock.Unlock()
+}
+
+func (hr *hookRecorder) recorded() []string {
+	hr.lock.Lock()
+	defer hr.lock.Unlock()
+	return append([]string(nil), hr.events...)
+}
+
+func (hr *hookRecorder) hooks() ResourceHooks {
+	return ResourceHooks{
+		OnCreate: func(key string, resource io.Closer) {
+			hr.record("create " + key)
+		},
+		OnRecreate: func(key string, resource io.Closer) {
+			hr.record("recreate " + key)
+		},
+		OnFailure: func(key string, err error) {
+			hr.record("failure " + key + ": " + err.Error())
+		},
+	}
+}
+
+func TestResourceManager_Invalidate(t *testing.T) {
+	recorder := &hookRecorder{}
+	manager := NewResourceManager(WithResourceHooks(recorder.hooks()))
+	defer func() {
+		_ = manager.Close()
+	}()
+
+	first := &countingResource{}
+	handle, err := manager.Acquire("key", func() (io.Closer, error) {
+		return first, nil
+	})
+	assert.NoError(t, err)
+
+	assert.True(t, manager.Invalidate("key"))
+	assert.False(t, manager.Invalidate("key"))
+	assert.Equal(t, int32(0), first.closedTimes())
+
+	second, err := manager.Get("key", func() (io.Closer, error) {
+		return &countingResource{}, nil
+	})
+	assert.NoError(t, err)
+	assert.NotSame(t, first, second)
+	assert.NoError(t, handle.Release())
+	assert.Equal(t, int32(1), first.closedTimes())
+
+	_, err = manager.Get("other", func() (io.Closer, error) {
+		return nil, errors.New("fail")
+	})
+	assert.Error(t, err)
+	_, err = manager.Get("other", func() (io.Closer, error) {
+		return &dummyResource{}, nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, manager.Remove("other"))
+
+	assert.Equal(t, []string{
+		"create key",
+		"recreate key",
+		"failure other: fail",
+		"create other",
+		"failure other: close",
+	}, recorder.recorded())
+}
+
+func TestResourceManager_HealthCheck(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	recorder := &hookRecorder{}
+	manager := NewResourceManager(WithHealthCheck(time.Millisecond), WithResourceHooks(recorder.hooks()))
+	defer func() {
+		_ = manager.Close()
+	}()
+
+	healthy := &healthResource{healthy: 1}
+	_, err := manager.Get("healthy", func() (io.Closer, error) {
+		return healthy, nil
+	})
+	assert.NoError(t, err)
+
+	broken := &healthResource{}
+	_, err = manager.Get("broken", func() (io.Closer, error) {
+		return broken, nil
+	})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return broken.closedTimes() == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(0), healthy.closedTimes())
+
+	recreated, err := manager.Get("broken", func() (io.Closer, error) {
+		return &healthResource{healthy: 1}, nil
+	})
+	assert.NoError(t, err)
+	assert.NotSame(t, broken, recreated)
+	assert.Contains(t, recorder.recorded(), "failure broken: unhealthy")
+	assert.Contains(t, recorder.recorded(), "recreate broken")
+}
+
+func TestResourceManager_GetContext(t *testing.T) {
+	manager := NewResourceManager()
+	defer func() {
+		_ = manager.Close()
+	}()
+
+	type ctxKey struct{}
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	created := make(chan struct{})
+	go func() {
+		defer close(created)
+		ctx := context.WithValue(context.Background(), ctxKey{}, "value")
+		_, err := manager.GetContext(ctx, "key", func(ctx context.Context) (io.Closer, error) {
+			assert.Equal(t, "value", ctx.Value(ctxKey{}))
+			close(started)
+			<-proceed
+			return &countingResource{}, nil
+		})
+		assert.NoError(t, err)
+	}()
+	<-started
+
+	// A waiter of the slow creation gives up.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := manager.GetContext(ctx, "key", func(ctx context.Context) (io.Closer, error) {
+		assert.Fail(t, "the creation in progress must be shared")
+		return nil, errors.New("unexpected creation")
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, err = manager.AcquireContext(ctx, "key", func(ctx context.Context) (io.Closer, error) {
+		assert.Fail(t, "the creation in progress must be shared")
+		return nil, errors.New("unexpected creation")
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(proceed)
+	<-created
+	handle, err := manager.AcquireContext(ctx, "key", func(ctx context.Context) (io.Closer, error) {
+		assert.Fail(t, "the resource must be reused")
+		return nil, errors.New("unexpected creation")
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, handle.Release())
+}
+
+func TestResourceManager_GiveUpCancelsCreation(t *testing.T) {
+	manager := NewResourceManager()
+	defer func() {
+		_ = manager.Close()
+	}()
+
+	canceled := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := manager.GetContext(ctx, "key", func(ctx context.Context) (io.Closer, error) {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	select {
+	case err = <-canceled:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		assert.Fail(t, "the creation wasn't cancelled")
+	}
+
+	// The next caller starts a new creation.
+	resource := &countingResource{}
+	got, err := manager.GetContext(context.Background(), "key", func(ctx context.Context) (io.Closer, error) {
+		return resource, nil
+	})
+	assert.NoError(t, err)
+	assert.Same(t, resource, got)
+}
+
+func TestResourceManager_CloseCancelsCreation(t *testing.T) {
+	manager := NewResourceManager()
+
+	started := make(chan struct{})
+	resource := &countingResource{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := manager.Get("key", func() (io.Closer, error) {
+			close(started)
+			time.Sleep(time.Millisecond * 20)
+			return resource, nil
+		})
+		assert.Equal(t, ErrClosed, err)
+	}()
+	<-started
+	assert.NoError(t, manager.Close())
+	<-done
+	assert.Equal(t, int32(1), resource.closedTimes())
+
+	canceled := make(chan error, 1)
+	manager = NewResourceManager()
+	go func() {
+		_, err := manager.GetContext(context.Background(), "key", func(ctx context.Context) (io.Closer, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+		canceled <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	assert.NoError(t, manager.Close())
+	assert.Equal(t, context.Canceled, <-canceled)
+}
+
+func TestResourceManager_ErrClosed(t *testing.T) {
+	manager := NewResourceManager()
+	first, second := &countingResource{}, &countingResource{}
+	_, err := manager.Get("first", func() (io.Closer, error) {
+		return first, nil
+	})
+	assert.NoError(t, err)
+	handle, err := manager.Acquire("second", func() (io.Closer, error) {
+		return second, nil
+	})
+	assert.NoError(t, err)
+
+	_, err = manager.Get("third", func() (io.Closer, error) {
+		return &dummyResource{}, nil
+	})
+	assert.NoError(t, err)
+	assert.EqualError(t, manager.Close(), "close")
+	assert.NoError(t, manager.Close())
+	assert.Equal(t, int32(1), first.closedTimes())
+	assert.Equal(t, int32(1), second.closedTimes())
+	assert.NoError(t, handle.Release())
+	assert.Equal(t, int32(1), second.closedTimes())
+
+	create := func() (io.Closer, error) {
+		return &countingResource{}, nil
+	}
+	_, err = manager.Get("first", create)
+	assert.Equal(t, ErrClosed, err)
+	_, err = manager.Acquire("first", create)
+	assert.Equal(t, ErrClosed, err)
+	assert.False(t, manager.Remove("first"))
+	assert.False(t, manager.Invalidate("first"))
+}
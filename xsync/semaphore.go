@@ -0,0 +1,135 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+type (
+	// Semaphore is a weighted semaphore, the waiters are served in FIFO order.
+	Semaphore struct {
+		size    int64
+		mu      sync.Mutex
+		cur     int64
+		waiters list.List
+	}
+
+	semaphoreWaiter struct {
+		n     int64
+		ready chan struct{}
+	}
+)
+
+// NewSemaphore returns a Semaphore with the given total weight.
+func NewSemaphore(n int64) *Semaphore {
+	if n < 1 {
+		panic("n should be greater than 0")
+	}
+
+	return &Semaphore{size: n}
+}
+
+// Acquire acquires the semaphore with a weight of n, or returns ctx.Err() if ctx is done first.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	s.checkWeight(n)
+
+	s.mu.Lock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	element := s.waiters.PushBack(semaphoreWaiter{n: n, ready: ready})
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-ready:
+			// Acquired meanwhile, give it back.
+			s.cur -= n
+			s.notifyWaitersLocked()
+		default:
+			isFront := s.waiters.Front() == element
+			s.waiters.Remove(element)
+			// The waiters behind the front one may fit now.
+			if isFront && s.size > s.cur {
+				s.notifyWaitersLocked()
+			}
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire acquires the semaphore with a weight of n without blocking and reports whether it succeeded.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	s.checkWeight(n)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release releases the semaphore with a weight of n.
+func (s *Semaphore) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cur -= n
+	if s.cur < 0 {
+		panic("semaphore released more than held")
+	}
+	s.notifyWaitersLocked()
+}
+
+func (s *Semaphore) checkWeight(n int64) {
+	if n < 1 || n > s.size {
+		panic("n should be between 1 and the size of the semaphore")
+	}
+}
+
+func (s *Semaphore) notifyWaitersLocked() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+
+		waiter := front.Value.(semaphoreWaiter)
+		if s.size-s.cur < waiter.n {
+			// Keep the FIFO order, so that large weights cannot starve.
+			return
+		}
+
+		s.cur += waiter.n
+		s.waiters.Remove(front)
+		close(waiter.ready)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := NewSemaphore(3)
+	assert.True(t, s.TryAcquire(2))
+	assert.False(t, s.TryAcquire(2))
+	assert.True(t, s.TryAcquire(1))
+	s.Release(3)
+	assert.True(t, s.TryAcquire(3))
+	s.Release(3)
+
+	assert.Panics(t, func() {
+		NewSemaphore(0)
+	})
+	assert.Panics(t, func() {
+		s.TryAcquire(4)
+	})
+	assert.Panics(t, func() {
+		s.Release(1)
+	})
+}
+
+func TestSemaphore_Acquire(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s := NewSemaphore(3)
+	assert.NoError(t, s.Acquire(context.Background(), 2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, s.Acquire(ctx, 2))
+
+	acquired := make(chan struct{})
+	go func() {
+		assert.NoError(t, s.Acquire(context.Background(), 3))
+		close(acquired)
+	}()
+
+	// The waiting large weight is served first.
+	assert.Eventually(t, func() bool {
+		return !s.TryAcquire(1)
+	}, time.Second, time.Millisecond)
+	s.Release(2)
+	<-acquired
+	s.Release(3)
+}
+
+func TestSemaphore_CancelFront(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	s := NewSemaphore(2)
+	assert.NoError(t, s.Acquire(context.Background(), 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	front := make(chan error)
+	go func() {
+		front <- s.Acquire(ctx, 2)
+	}()
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.waiters.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	behind := make(chan struct{})
+	go func() {
+		assert.NoError(t, s.Acquire(context.Background(), 1))
+		close(behind)
+	}()
+
+	// The waiter behind the cancelled front one fits.
+	cancel()
+	assert.Equal(t, context.Canceled, <-front)
+	<-behind
+	s.Release(2)
+}
+
+func TestSemaphore_Concurrent(t *testing.T) {
+	s := NewSemaphore(4)
+	var (
+		wait    sync.WaitGroup
+		current int64
+	)
+	for g := 0; g < 16; g++ {
+		wait.Add(1)
+		go func(n int64) {
+			defer wait.Done()
+			for i := 0; i < 100; i++ {
+				assert.NoError(t, s.Acquire(context.Background(), n))
+				assert.LessOrEqual(t, atomic.AddInt64(&current, n), int64(4))
+				atomic.AddInt64(&current, -n)
+				s.Release(n)
+			}
+		}(int64(g%4 + 1))
+	}
+	wait.Wait()
+}
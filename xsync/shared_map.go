@@ -16,21 +16,61 @@
 
 package xsync
 
+import (
+	"hash/maphash"
+	"sync"
+	"unsafe"
+)
+
 type (
+	// Hasher hashes a key of SharedMap.
+	Hasher func(key string) uint64
+
 	// SharedBlockMap is an alias that describes the block map.
 	SharedBlockMap = Map
 	// SharedMap represents a segment lock map.
+	//
+	// The number of segments can be changed at runtime with Resize or WithAutoGrow,
+	// the elements are migrated one segment at a time while the other segments stay available.
 	SharedMap struct {
-		b              []*SharedBlockMap
-		shardBlockSize uint32
-		n              uint32
+		table    unsafe.Pointer // *sharedTable
+		resizeMu sync.Mutex
+		hasher   Hasher
+
+		autoGrow int
+		writes   uint32
+
+		// watchers are notified after every mutation made through the SharedMap.
+		watchers watchers
 	}
 	// sharedMapOptions configuration of SharedMap.
 	sharedMapOptions struct {
 		shardBlockSize uint32
+		hasher         Hasher
+		autoGrow       int
+	}
+	// ShardStats describes the distribution of the elements over the shards.
+	ShardStats struct {
+		// Lengths is the number of elements of every shard.
+		Lengths []int
+		// Min is the number of elements of the smallest shard.
+		Min int
+		// Max is the number of elements of the largest shard.
+		Max int
+		// Mean is the average number of elements per shard.
+		Mean float64
+		// Skew is Max divided by Mean, 1 means the elements are evenly distributed.
+		// It's 0 for an empty map.
+		Skew float64
 	}
 	// SharedMapOption configuration function of SharedMap.
 	SharedMapOption func(*sharedMapOptions)
+
+	// rangeUnit is a shard to visit, skip reports the keys of the shard which must not be visited.
+	rangeUnit struct {
+		shard *sharedShard
+		skip  func(key interface{}) bool
+	}
 )
 
 // WithShardBlockSize returns a configuration that sets the size of the number of segments.
@@ -40,6 +80,25 @@ func WithShardBlockSize(shardBlockSize int) SharedMapOption {
 	}
 }
 
+// WithHasher returns a configuration that sets the Hasher used to shard the keys.
+// Defaults to FNV-1 32-bit.
+func WithHasher(hasher Hasher) SharedMapOption {
+	return func(sharedMapOptions *sharedMapOptions) {
+		sharedMapOptions.hasher = hasher
+	}
+}
+
+// NewMapHasher returns a Hasher based on hash/maphash with a random seed.
+func NewMapHasher() Hasher {
+	seed := maphash.MakeSeed()
+	return func(key string) uint64 {
+		var h maphash.Hash
+		h.SetSeed(seed)
+		_, _ = h.WriteString(key)
+		return h.Sum64()
+	}
+}
+
 // NewSharedMap returns a SharedMap.
 func NewSharedMap(opts ...SharedMapOption) *SharedMap {
 	options := new(sharedMapOptions)
@@ -47,39 +106,58 @@ func NewSharedMap(opts ...SharedMapOption) *SharedMap {
 	for _, opt := range opts {
 		opt(options)
 	}
+	if options.hasher == nil {
+		options.hasher = fnv32Hasher
+	}
 
 	if options.shardBlockSize <= 0 {
 		panic("error")
 	}
-	blockSize := getShardBlockSize(options.shardBlockSize)
-	b := make([]*SharedBlockMap, blockSize)
-	for i := uint32(0); i < blockSize; i++ {
-		b[i] = &Map{}
-	}
-	return &SharedMap{
-		b:              b,
-		n:              blockSize - 1,
-		shardBlockSize: blockSize,
+	m := &SharedMap{
+		hasher:   options.hasher,
+		autoGrow: options.autoGrow,
 	}
+	m.storeTable(newSharedTable(getShardBlockSize(options.shardBlockSize), nil))
+	return m
 }
 
 // ComputeIfAbsent  if the value corresponding to the key does not exist,
 // use the recalculated value obtained by remappingFunction and save it as the value of the key,
 // otherwise return the value.
 func (m *SharedMap) ComputeIfAbsent(key string, computeFunc func(key string) interface{}) (actual interface{}, loaded bool) {
-	shard := m.GetShard(key)
-	return shard.ComputeIfAbsent(key, func(key interface{}) interface{} {
-		return computeFunc(key.(string))
+	hash := m.hasher(key)
+	if _, shard := m.unlocked(hash); shard != nil {
+		if actual, loaded = shard.m.Load(key); loaded {
+			return actual, true
+		}
+	}
+
+	m.locked(hash, func(shard *SharedBlockMap) {
+		actual, loaded = shard.ComputeIfAbsent(key, func(key interface{}) interface{} {
+			return computeFunc(key.(string))
+		})
 	})
+
+	if !loaded {
+		m.wrote(1)
+		m.notify(EventStore, key, actual)
+	}
+	return
 }
 
 // ComputeIfPresent if the value corresponding to the key does not exist,
 // the null is returned, and if it exists, the value recalculated by remappingFunction is returned.
 func (m *SharedMap) ComputeIfPresent(key string, computeFunc func(key string, value interface{}) interface{}) (actual interface{}, exist bool) {
-	shard := m.GetShard(key)
-	return shard.ComputeIfPresent(key, func(key, value interface{}) interface{} {
-		return computeFunc(key.(string), value)
+	m.locked(m.hasher(key), func(shard *SharedBlockMap) {
+		actual, exist = shard.ComputeIfPresent(key, func(key, value interface{}) interface{} {
+			return computeFunc(key.(string), value)
+		})
 	})
+
+	if exist {
+		m.notify(EventStore, key, actual)
+	}
+	return
 }
 
 func getShardBlockSize(shardBlockSize uint32) uint32 {
@@ -93,29 +171,54 @@ func getShardBlockSize(shardBlockSize uint32) uint32 {
 }
 
 // GetShard returns shard under given key.
-func (m SharedMap) GetShard(key string) *SharedBlockMap {
-	return m.b[fnv32(key)&m.n]
-}
-
-// MStore sets multiple keys and values.
-func (m SharedMap) MStore(data map[string]interface{}) {
-	for key, value := range data {
-		m.Store(key, value)
+//
+// While the map is being resized the key may be moved to another shard right after
+// GetShard returned, prefer the methods of SharedMap to access the key.
+func (m *SharedMap) GetShard(key string) *SharedBlockMap {
+	hash := m.hasher(key)
+	if _, shard := m.unlocked(hash); shard != nil {
+		return shard.m
 	}
+
+	shard := m.acquire(hash)
+	shard.release()
+	return shard.m
 }
 
 // Store sets the value for a key.
-func (m SharedMap) Store(key string, value interface{}) {
-	// Get map shard.
-	shard := m.GetShard(key)
-	shard.Store(key, value)
+func (m *SharedMap) Store(key string, value interface{}) {
+	hash := m.hasher(key)
+	table, shard := m.unlocked(hash)
+	if shard != nil {
+		shard.m.Store(key, value)
+	}
+	if shard == nil || !m.written(table, shard) {
+		shard = m.acquire(hash)
+		shard.m.Store(key, value)
+		shard.release()
+	}
+
+	m.wrote(1)
+	m.notify(EventStore, key, value)
 }
 
 // LoadOrStore the given value under the specified key if no value was associated with it.
 func (m *SharedMap) LoadOrStore(key string, value interface{}) bool {
-	// Get map shard.
-	shard := m.GetShard(key)
-	_, loaded := shard.LoadOrStore(key, value)
+	hash := m.hasher(key)
+	if _, shard := m.unlocked(hash); shard != nil {
+		if _, ok := shard.m.Load(key); ok {
+			return false
+		}
+	}
+
+	shard := m.acquire(hash)
+	_, loaded := shard.m.LoadOrStore(key, value)
+	shard.release()
+
+	if !loaded {
+		m.wrote(1)
+		m.notify(EventStore, key, value)
+	}
 	return !loaded
 }
 
@@ -123,50 +226,220 @@ func (m *SharedMap) LoadOrStore(key string, value interface{}) bool {
 // value is present.
 // The ok result indicates whether value was found in the map.
 func (m *SharedMap) Load(key string) (interface{}, bool) {
-	// Get shard
-	shard := m.GetShard(key)
-	return shard.Load(key)
+	hash := m.hasher(key)
+	if _, shard := m.unlocked(hash); shard != nil {
+		return shard.m.Load(key)
+	}
+
+	shard := m.acquire(hash)
+	value, ok := shard.m.Load(key)
+	shard.release()
+	return value, ok
 }
 
 // Range calls f sequentially for each key and value present in the map.
 // If f returns false, range stops the iteration.
+//
+// No key is visited more than once, even if the map is resized concurrently.
 func (m *SharedMap) Range(fn func(key, value interface{}) bool) {
+	for _, unit := range m.rangeUnits() {
+		if !unit.rangeShard(fn) {
+			return
+		}
+	}
+}
 
-	for i := uint32(0); i < m.shardBlockSize; i++ {
-		shard := m.b[i]
-		b := true
-		shard.Range(func(key, value interface{}) bool {
-			b = fn(key, value)
-			return b
-		})
-		if !b {
-			break
+// rangeUnits returns the shards to visit in order to visit every key once.
+func (m *SharedMap) rangeUnits() []rangeUnit {
+	table := m.loadTable()
+	prev := table.prev
+	if prev == nil {
+		units := make([]rangeUnit, len(table.shards))
+		for i, shard := range table.shards {
+			units[i] = rangeUnit{shard: shard}
+		}
+		return units
+	}
+
+	// While resizing, the keys of the shards of prev which are not moved yet are visited
+	// in prev, and skipped in table, where they may arrive during the iteration.
+	units := make([]rangeUnit, 0, len(prev.shards)+len(table.shards))
+	visited := make(map[uint64]bool, len(prev.shards))
+	for i, shard := range prev.shards {
+		if shard.isMoved() {
+			continue
 		}
+
+		visited[uint64(i)] = true
+		units = append(units, rangeUnit{shard: shard})
+	}
+
+	skip := func(key interface{}) bool {
+		return visited[m.hasher(key.(string))&prev.mask]
+	}
+	for _, shard := range table.shards {
+		units = append(units, rangeUnit{shard: shard, skip: skip})
 	}
+	return units
+}
+
+func (u rangeUnit) rangeShard(fn func(key, value interface{}) bool) bool {
+	b := true
+	u.shard.m.Range(func(key, value interface{}) bool {
+		if u.skip != nil && u.skip(key) {
+			return true
+		}
+		b = fn(key, value)
+		return b
+	})
+	return b
 }
 
 // Has looks up an item under specified key.
 func (m *SharedMap) Has(key string) bool {
-	// Get shard
-	shard := m.GetShard(key)
-	_, ok := shard.Load(key)
+	_, ok := m.Load(key)
 	return ok
 }
 
 // Delete deletes an element from the map.
-func (m SharedMap) Delete(key string) {
-	// Try to get shard.
-	shard := m.GetShard(key)
-	shard.Delete(key)
+func (m *SharedMap) Delete(key string) {
+	var (
+		value  interface{}
+		loaded bool
+	)
+	hash := m.hasher(key)
+	table, shard := m.unlocked(hash)
+	if shard != nil {
+		value, loaded = shard.m.LoadAndDelete(key)
+	}
+	if shard == nil || !m.written(table, shard) {
+		shard = m.acquire(hash)
+		v, ok := shard.m.LoadAndDelete(key)
+		shard.release()
+		if !loaded {
+			value, loaded = v, ok
+		}
+	}
+
+	if loaded {
+		m.notify(EventDelete, key, value)
+	}
+}
+
+// notify notifies the watchers of a mutation of key, which is only converted to an interface if watched.
+func (m *SharedMap) notify(eventType EventType, key string, value interface{}) {
+	if m.watchers.watched() {
+		m.watchers.notify(eventType, key, value)
+	}
 }
 
 // Clear removes all items from map.
-func (m SharedMap) Clear() {
-	for _, b := range m.b {
-		b.Clear()
+//
+// Clear waits for a resize in progress to finish.
+func (m *SharedMap) Clear() {
+	m.resizeMu.Lock()
+	for _, shard := range m.loadTable().shards {
+		shard.m.Clear()
+	}
+	m.resizeMu.Unlock()
+
+	m.watchers.notify(EventClear, nil, nil)
+}
+
+// Len returns the number of elements in the map.
+//
+// Len sums up the O(1) length of every shard, under concurrent writes
+// it may not correspond to any consistent state of the whole map.
+func (m *SharedMap) Len() int {
+	return m.loadTable().len()
+}
+
+// Keys returns all keys present in the map.
+//
+// Keys has the same consistency as Range.
+func (m *SharedMap) Keys() []string {
+	keys := make([]string, 0, m.Len())
+	m.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(string))
+		return true
+	})
+	return keys
+}
+
+// Snapshot returns a copy of all keys and values present in the map.
+//
+// Snapshot has the same consistency as Range,
+// every shard is copied separately while the other shards may be written.
+func (m *SharedMap) Snapshot() map[string]interface{} {
+	snapshot := make(map[string]interface{}, m.Len())
+	m.Range(func(key, value interface{}) bool {
+		snapshot[key.(string)] = value
+		return true
+	})
+	return snapshot
+}
+
+// SnapshotShards calls fn with a copy of every shard. A shard is copied like Range visits it,
+// every key holds a value it had during the copy. If fn returns false, SnapshotShards stops the iteration.
+//
+// SnapshotShards waits for a resize in progress to finish and blocks the resizes until it returns.
+func (m *SharedMap) SnapshotShards(fn func(snapshot map[string]interface{}) bool) {
+	m.resizeMu.Lock()
+	defer m.resizeMu.Unlock()
+
+	for _, shard := range m.loadTable().shards {
+		snapshot := make(map[string]interface{}, shard.m.Len())
+		shard.m.Range(func(key, value interface{}) bool {
+			snapshot[key.(string)] = value
+			return true
+		})
+
+		if !fn(snapshot) {
+			return
+		}
 	}
 }
 
+// ShardStats returns the distribution of the elements over the shards,
+// it's used to detect a skewed Hasher.
+//
+// ShardStats waits for a resize in progress to finish.
+func (m *SharedMap) ShardStats() ShardStats {
+	m.resizeMu.Lock()
+	defer m.resizeMu.Unlock()
+
+	shards := m.loadTable().shards
+	lengths := make([]int, len(shards))
+	for i, shard := range shards {
+		lengths[i] = shard.m.Len()
+	}
+	return newShardStats(lengths)
+}
+
+func newShardStats(lengths []int) ShardStats {
+	stats := ShardStats{Lengths: lengths, Min: lengths[0]}
+	total := 0
+	for _, length := range lengths {
+		total += length
+		if length < stats.Min {
+			stats.Min = length
+		}
+		if length > stats.Max {
+			stats.Max = length
+		}
+	}
+
+	stats.Mean = float64(total) / float64(len(lengths))
+	if total > 0 {
+		stats.Skew = float64(stats.Max) / stats.Mean
+	}
+	return stats
+}
+
+func fnv32Hasher(key string) uint64 {
+	return uint64(fnv32(key))
+}
+
 func fnv32(key string) uint32 {
 	hash := uint32(2166136261)
 	const prime32 = uint32(16777619)
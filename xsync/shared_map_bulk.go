@@ -0,0 +1,216 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelRange calls f for each key and value present in the map,
+// visiting the shards concurrently in the given number of workers.
+// f must be safe for concurrent use. If f returns false, ParallelRange stops
+// the iteration, calls of f already in progress in the other workers still finish.
+//
+// ParallelRange has the same consistency as Range.
+func (m *SharedMap) ParallelRange(fn func(key, value interface{}) bool, workers int) {
+	if workers < 1 {
+		panic("workers should be greater than 0")
+	}
+
+	units := m.rangeUnits()
+	var (
+		next      int64 = -1
+		stopped   int32
+		waitGroup sync.WaitGroup
+	)
+	visit := func(key, value interface{}) bool {
+		if atomic.LoadInt32(&stopped) == 1 {
+			return false
+		}
+		if !fn(key, value) {
+			atomic.StoreInt32(&stopped, 1)
+			return false
+		}
+		return true
+	}
+
+	waitGroup.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer waitGroup.Done()
+			for {
+				idx := atomic.AddInt64(&next, 1)
+				if idx >= int64(len(units)) || !units[idx].rangeShard(visit) {
+					return
+				}
+			}
+		}()
+	}
+	waitGroup.Wait()
+}
+
+// MStore sets multiple keys and values.
+// The keys are grouped by segment, the keys of a segment which can't be stored without
+// locking it are stored under a single lock of the segment.
+func (m *SharedMap) MStore(data map[string]interface{}) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+
+	m.forEachShard(keys, true, func(shard *SharedBlockMap, keys []string) {
+		shard.storeAll(keys, data)
+	})
+	m.wrote(len(keys))
+	for key, value := range data {
+		m.notify(EventStore, key, value)
+	}
+}
+
+// MLoad returns the values stored in the map for the given keys.
+// The keys are grouped by segment, the keys of a segment which can't be loaded without
+// locking it are loaded under a single lock of the segment.
+// Keys without value are absent in the result.
+func (m *SharedMap) MLoad(keys []string) map[string]interface{} {
+	values := make(map[string]interface{}, len(keys))
+	m.forEachShard(keys, false, func(shard *SharedBlockMap, keys []string) {
+		shard.loadAll(keys, func(key string, value interface{}) {
+			values[key] = value
+		})
+	})
+	return values
+}
+
+// MDelete deletes the given keys.
+// The keys are grouped by segment, the keys of a segment which can't be deleted without
+// locking it are deleted under a single lock of the segment.
+func (m *SharedMap) MDelete(keys []string) {
+	var (
+		events []Event
+		seen   map[string]bool
+	)
+	m.forEachShard(keys, true, func(shard *SharedBlockMap, keys []string) {
+		shard.loadAndDeleteAll(keys, func(key string, value interface{}) {
+			if !m.watchers.watched() || seen[key] {
+				return
+			}
+			if seen == nil {
+				seen = make(map[string]bool)
+			}
+			// A key deleted again after a resize moved its segment is reported once.
+			seen[key] = true
+			events = append(events, Event{Type: EventDelete, Key: key, Value: value})
+		})
+	})
+	m.notifyAll(events)
+}
+
+// DeleteIf deletes all elements for which pred returns true and returns the number of deleted elements.
+// pred is checked again atomically with the deletion, so an element updated concurrently
+// is only deleted if pred still holds for its new value.
+func (m *SharedMap) DeleteIf(pred func(key string, value interface{}) bool) int {
+	var events []Event
+	deleted := 0
+	deleteIf := func(shard *SharedBlockMap, key string) {
+		removed := false
+		var value interface{}
+		shard.Compute(key, func(oldValue interface{}, exists bool) (interface{}, bool) {
+			removed, value = exists && pred(key, oldValue), oldValue
+			return oldValue, exists && !removed
+		})
+		if removed {
+			deleted++
+			if m.watchers.watched() {
+				events = append(events, Event{Type: EventDelete, Key: key, Value: value})
+			}
+		}
+	}
+
+	for _, unit := range m.rangeUnits() {
+		var keys []string
+		unit.rangeShard(func(key, value interface{}) bool {
+			if pred(key.(string), value) {
+				keys = append(keys, key.(string))
+			}
+			return true
+		})
+
+		if unit.shard.tryLocked(func() {
+			for _, key := range keys {
+				deleteIf(unit.shard.m, key)
+			}
+		}) {
+			continue
+		}
+
+		// The shard was moved by a resize meanwhile.
+		for _, key := range keys {
+			m.locked(m.hasher(key), func(shard *SharedBlockMap) {
+				deleteIf(shard, key)
+			})
+		}
+	}
+
+	m.notifyAll(events)
+	return deleted
+}
+
+// forEachShard groups keys by shard and calls fn once per shard.
+// While no resize is in progress, fn is called without locking the shard,
+// and if write is true, the keys of a shard moved by a resize meanwhile are written again one by one.
+// While resizing, fn is called for every key while holding its shard.
+func (m *SharedMap) forEachShard(keys []string, write bool, fn func(shard *SharedBlockMap, keys []string)) {
+	table := m.loadTable()
+	if table.prev != nil {
+		// Resizing, the keys may be in either table.
+		for _, key := range keys {
+			m.locked(m.hasher(key), func(shard *SharedBlockMap) {
+				fn(shard, []string{key})
+			})
+		}
+		return
+	}
+
+	groups := make(map[uint64][]string)
+	for _, key := range keys {
+		idx := m.hasher(key) & table.mask
+		groups[idx] = append(groups[idx], key)
+	}
+
+	for idx, group := range groups {
+		shard := table.shards[idx]
+		fn(shard.m, group)
+		if !write || m.written(table, shard) {
+			continue
+		}
+
+		// The shard was moved by a resize before the write.
+		for _, key := range group {
+			m.locked(m.hasher(key), func(shard *SharedBlockMap) {
+				fn(shard, []string{key})
+			})
+		}
+	}
+}
+
+// notifyAll notifies the watchers of events collected while holding shards.
+func (m *SharedMap) notifyAll(events []Event) {
+	for _, event := range events {
+		m.watchers.notify(event.Type, event.Key, event.Value)
+	}
+}
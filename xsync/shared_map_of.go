@@ -0,0 +1,260 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"hash/maphash"
+	"math"
+	"reflect"
+)
+
+// SharedMapOf represents a segment lock map with keys of any comparable type.
+type SharedMapOf[K comparable, V any] struct {
+	b      []*TypedMap[K, V]
+	n      uint64
+	hasher func(key K) uint64
+}
+
+// NewSharedMapOf returns a SharedMapOf which shards the keys with hasher.
+// If hasher is nil, DefaultHasher is used.
+// The only SharedMapOption taken into account is WithShardBlockSize.
+func NewSharedMapOf[K comparable, V any](hasher func(key K) uint64, opts ...SharedMapOption) *SharedMapOf[K, V] {
+	options := new(sharedMapOptions)
+	options.shardBlockSize = 32
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.shardBlockSize <= 0 {
+		panic("error")
+	}
+	if hasher == nil {
+		hasher = DefaultHasher[K]()
+	}
+
+	blockSize := getShardBlockSize(options.shardBlockSize)
+	b := make([]*TypedMap[K, V], blockSize)
+	for i := range b {
+		b[i] = &TypedMap[K, V]{}
+	}
+	return &SharedMapOf[K, V]{
+		b:      b,
+		n:      uint64(blockSize - 1),
+		hasher: hasher,
+	}
+}
+
+// DefaultHasher returns a hasher based on hash/maphash with a random seed.
+// Strings, integers and floats are hashed directly, keys of other types are hashed
+// field by field with reflection, which is slower. Provide a dedicated hasher for hot keys.
+// Equal keys always have the same hash, +0 and -0 included.
+func DefaultHasher[K comparable]() func(key K) uint64 {
+	seed := maphash.MakeSeed()
+	return func(key K) uint64 {
+		var h maphash.Hash
+		h.SetSeed(seed)
+
+		switch k := any(key).(type) {
+		case string:
+			_, _ = h.WriteString(k)
+		case int:
+			writeUint64(&h, uint64(k))
+		case int8:
+			writeUint64(&h, uint64(k))
+		case int16:
+			writeUint64(&h, uint64(k))
+		case int32:
+			writeUint64(&h, uint64(k))
+		case int64:
+			writeUint64(&h, uint64(k))
+		case uint:
+			writeUint64(&h, uint64(k))
+		case uint8:
+			writeUint64(&h, uint64(k))
+		case uint16:
+			writeUint64(&h, uint64(k))
+		case uint32:
+			writeUint64(&h, uint64(k))
+		case uint64:
+			writeUint64(&h, k)
+		case uintptr:
+			writeUint64(&h, uint64(k))
+		case float32:
+			writeFloat64(&h, float64(k))
+		case float64:
+			writeFloat64(&h, k)
+		default:
+			writeValue(&h, reflect.ValueOf(any(key)))
+		}
+		return h.Sum64()
+	}
+}
+
+// writeValue hashes v so that values equal by == have the same hash.
+func writeValue(h *maphash.Hash, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Invalid:
+		// A nil interface.
+		_ = h.WriteByte(0)
+	case reflect.Bool:
+		if v.Bool() {
+			_ = h.WriteByte(1)
+		} else {
+			_ = h.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeUint64(h, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeUint64(h, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeFloat64(h, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		writeFloat64(h, real(c))
+		writeFloat64(h, imag(c))
+	case reflect.String:
+		_, _ = h.WriteString(v.String())
+	case reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		writeUint64(h, uint64(v.Pointer()))
+	case reflect.Interface:
+		writeValue(h, v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			writeValue(h, v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			writeValue(h, v.Field(i))
+		}
+	default:
+		// Not comparable, == panics for such keys anyway.
+		panic("unhashable key type " + v.Type().String())
+	}
+}
+
+func writeUint64(h *maphash.Hash, v uint64) {
+	var b [8]byte
+	for i := range b {
+		b[i] = byte(v >> (8 * i))
+	}
+	_, _ = h.Write(b[:])
+}
+
+func writeFloat64(h *maphash.Hash, f float64) {
+	if f == 0 {
+		// +0 and -0 are equal keys.
+		f = 0
+	}
+	writeUint64(h, math.Float64bits(f))
+}
+
+// GetShard returns shard under given key.
+func (m *SharedMapOf[K, V]) GetShard(key K) *TypedMap[K, V] {
+	return m.b[m.hasher(key)&m.n]
+}
+
+// Store sets the value for a key.
+func (m *SharedMapOf[K, V]) Store(key K, value V) {
+	m.GetShard(key).Store(key, value)
+}
+
+// MStore sets multiple keys and values.
+func (m *SharedMapOf[K, V]) MStore(data map[K]V) {
+	for key, value := range data {
+		m.Store(key, value)
+	}
+}
+
+// Load returns the value stored in the map for a key, or the zero value if no
+// value is present.
+// The ok result indicates whether value was found in the map.
+func (m *SharedMapOf[K, V]) Load(key K) (V, bool) {
+	return m.GetShard(key).Load(key)
+}
+
+// LoadOrStore returns the existing value for the key if present.
+// Otherwise, it stores and returns the given value.
+// The loaded result is true if the value was loaded, false if stored.
+func (m *SharedMapOf[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	return m.GetShard(key).LoadOrStore(key, value)
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *SharedMapOf[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	return m.GetShard(key).LoadAndDelete(key)
+}
+
+// ComputeIfAbsent  if the value corresponding to the key does not exist,
+// use the recalculated value obtained by remappingFunction and save it as the value of the key,
+// otherwise return the value.
+func (m *SharedMapOf[K, V]) ComputeIfAbsent(key K, computeFunc func(key K) V) (actual V, loaded bool) {
+	return m.GetShard(key).ComputeIfAbsent(key, computeFunc)
+}
+
+// ComputeIfPresent if the value corresponding to the key does not exist,
+// the zero value is returned, and if it exists, the value recalculated by remappingFunction is returned.
+func (m *SharedMapOf[K, V]) ComputeIfPresent(key K, computeFunc func(key K, value V) V) (actual V, exist bool) {
+	return m.GetShard(key).ComputeIfPresent(key, computeFunc)
+}
+
+// Has looks up an item under specified key.
+func (m *SharedMapOf[K, V]) Has(key K) bool {
+	_, ok := m.Load(key)
+	return ok
+}
+
+// Delete deletes an element from the map.
+func (m *SharedMapOf[K, V]) Delete(key K) {
+	m.GetShard(key).Delete(key)
+}
+
+// Range calls f sequentially for each key and value present in the map.
+// If f returns false, range stops the iteration.
+func (m *SharedMapOf[K, V]) Range(fn func(key K, value V) bool) {
+	for _, shard := range m.b {
+		b := true
+		shard.Range(func(key K, value V) bool {
+			b = fn(key, value)
+			return b
+		})
+		if !b {
+			break
+		}
+	}
+}
+
+// Clear removes all items from map.
+func (m *SharedMapOf[K, V]) Clear() {
+	for _, b := range m.b {
+		b.Clear()
+	}
+}
+
+// ShardStats returns the distribution of the elements over the shards,
+// it's used to detect a skewed hasher.
+// Unlike SharedMap.ShardStats, it ranges over all elements.
+func (m *SharedMapOf[K, V]) ShardStats() ShardStats {
+	lengths := make([]int, len(m.b))
+	for i, b := range m.b {
+		b.Range(func(K, V) bool {
+			lengths[i]++
+			return true
+		})
+	}
+	return newShardStats(lengths)
+}
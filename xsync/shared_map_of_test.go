@@ -0,0 +1,140 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct {
+	x, y int
+}
+
+func TestSharedMapOf(t *testing.T) {
+	m := NewSharedMapOf[point, string](nil, WithShardBlockSize(8))
+
+	m.Store(point{1, 2}, "a")
+	m.MStore(map[point]string{{3, 4}: "b", {5, 6}: "c"})
+
+	v, ok := m.Load(point{1, 2})
+	assert.True(t, ok)
+	assert.Equal(t, "a", v)
+	assert.True(t, m.Has(point{3, 4}))
+	assert.False(t, m.Has(point{0, 0}))
+
+	actual, loaded := m.LoadOrStore(point{1, 2}, "x")
+	assert.True(t, loaded)
+	assert.Equal(t, "a", actual)
+
+	actual, loaded = m.ComputeIfAbsent(point{7, 8}, func(key point) string {
+		return "d"
+	})
+	assert.False(t, loaded)
+	assert.Equal(t, "d", actual)
+
+	actual, exist := m.ComputeIfPresent(point{7, 8}, func(key point, value string) string {
+		return value + value
+	})
+	assert.True(t, exist)
+	assert.Equal(t, "dd", actual)
+
+	v, loaded = m.LoadAndDelete(point{5, 6})
+	assert.True(t, loaded)
+	assert.Equal(t, "c", v)
+	m.Delete(point{3, 4})
+
+	count := 0
+	m.Range(func(key point, value string) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 2, count)
+
+	stats := m.ShardStats()
+	assert.Len(t, stats.Lengths, 8)
+	assert.Equal(t, 2, sum(stats.Lengths))
+
+	m.Clear()
+	assert.False(t, m.Has(point{1, 2}))
+}
+
+func TestSharedMapOfCustomHasher(t *testing.T) {
+	m := NewSharedMapOf[int, int](func(key int) uint64 {
+		return uint64(key)
+	}, WithShardBlockSize(4))
+	for i := 0; i < 8; i++ {
+		m.Store(i, i)
+	}
+
+	assert.Equal(t, []int{2, 2, 2, 2}, m.ShardStats().Lengths)
+	assert.Equal(t, 1.0, m.ShardStats().Skew)
+}
+
+func TestDefaultHasher(t *testing.T) {
+	floatHasher := DefaultHasher[float64]()
+	assert.Equal(t, floatHasher(0), floatHasher(math.Copysign(0, -1)))
+
+	stringHasher := DefaultHasher[string]()
+	assert.Equal(t, stringHasher("key"), stringHasher("key"))
+
+	pointHasher := DefaultHasher[point]()
+	assert.Equal(t, pointHasher(point{1, 2}), pointHasher(point{1, 2}))
+	assert.NotEqual(t, pointHasher(point{1, 2}), pointHasher(point{2, 1}))
+
+	type floatKey struct {
+		F float64
+		A [2]float32
+		P *int
+	}
+	negativeZero := math.Copysign(0, -1)
+	floatKeyHasher := DefaultHasher[floatKey]()
+	assert.Equal(t, floatKeyHasher(floatKey{F: 0, A: [2]float32{0, 1}}),
+		floatKeyHasher(floatKey{F: negativeZero, A: [2]float32{float32(negativeZero), 1}}))
+
+	keys := NewSharedMapOf[floatKey, int](nil)
+	keys.Store(floatKey{F: 0}, 1)
+	keys.Store(floatKey{F: negativeZero}, 2)
+	value, ok := keys.Load(floatKey{F: 0})
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+	pointer := new(int)
+	assert.Equal(t, floatKeyHasher(floatKey{P: pointer}), floatKeyHasher(floatKey{P: pointer}))
+
+	m := NewSharedMapOf[uint8, int](nil)
+	for i := 0; i < 256; i++ {
+		m.Store(uint8(i), i)
+	}
+	// The small keys are spread over the shards instead of piling up in a few of them.
+	used := 0
+	for _, length := range m.ShardStats().Lengths {
+		if length > 0 {
+			used++
+		}
+	}
+	assert.Greater(t, used, len(m.ShardStats().Lengths)/2)
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,261 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// maxShardBlockSize is the maximum number of segments a SharedMap grows to automatically.
+	maxShardBlockSize = 1 << 16
+	// autoGrowInterval is the number of writes between two checks of the automatic growth.
+	autoGrowInterval = 1 << 10
+)
+
+type (
+	// sharedTable is an immutable set of shards.
+	sharedTable struct {
+		shards []*sharedShard
+		mask   uint64
+		// prev is the table whose shards are being moved into shards, nil if no resize is in progress.
+		prev *sharedTable
+	}
+
+	// sharedShard is a segment of a SharedMap.
+	//
+	// While no resize is in progress, the segments are accessed without locking mu.
+	// During a resize every access holds mu for reading, and the resize holds mu for writing
+	// while moving the elements to the next table.
+	sharedShard struct {
+		mu sync.RWMutex
+		m  *SharedBlockMap
+		// moved reports whether the elements were moved to the next table,
+		// it's guarded by mu.
+		moved bool
+	}
+)
+
+// WithAutoGrow returns a configuration that doubles the number of segments in the background
+// once the average number of elements per segment exceeds maxAverageShardLen.
+// The number of elements per segment is used as a measure of the lock contention,
+// the map doesn't grow beyond 65536 segments.
+func WithAutoGrow(maxAverageShardLen int) SharedMapOption {
+	return func(sharedMapOptions *sharedMapOptions) {
+		sharedMapOptions.autoGrow = maxAverageShardLen
+	}
+}
+
+func newSharedTable(shardBlockSize uint32, prev *sharedTable) *sharedTable {
+	shards := make([]*sharedShard, shardBlockSize)
+	for i := range shards {
+		shards[i] = &sharedShard{m: &SharedBlockMap{}}
+	}
+
+	return &sharedTable{
+		shards: shards,
+		mask:   uint64(shardBlockSize - 1),
+		prev:   prev,
+	}
+}
+
+// len returns the number of elements of the table and of the shards of prev which are not moved yet.
+func (t *sharedTable) len() int {
+	length := 0
+	if t.prev != nil {
+		for _, shard := range t.prev.shards {
+			shard.mu.RLock()
+			if !shard.moved {
+				length += shard.m.Len()
+			}
+			shard.mu.RUnlock()
+		}
+	}
+
+	for _, shard := range t.shards {
+		length += shard.m.Len()
+	}
+	return length
+}
+
+func (s *sharedShard) isMoved() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.moved
+}
+
+// tryAcquire locks s for reading if its elements were not moved to the next table.
+func (s *sharedShard) tryAcquire() bool {
+	s.mu.RLock()
+	if s.moved {
+		s.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
+// tryLocked calls fn while holding s for reading, unless its elements were moved to the next table.
+// It reports whether fn was called.
+func (s *sharedShard) tryLocked(fn func()) bool {
+	if !s.tryAcquire() {
+		return false
+	}
+	defer s.release()
+
+	fn()
+	return true
+}
+
+func (s *sharedShard) release() {
+	s.mu.RUnlock()
+}
+
+func (m *SharedMap) loadTable() *sharedTable {
+	return (*sharedTable)(atomic.LoadPointer(&m.table))
+}
+
+func (m *SharedMap) storeTable(table *sharedTable) {
+	atomic.StorePointer(&m.table, unsafe.Pointer(table))
+}
+
+// unlocked returns the table and the shard holding the key of hash if no resize is in progress,
+// the shard is used without locking. It returns a nil shard while resizing.
+//
+// A read of the shard is consistent even if a resize moves it meanwhile, as a moved shard keeps
+// the elements it had when moved. A write must be checked with written.
+func (m *SharedMap) unlocked(hash uint64) (*sharedTable, *sharedShard) {
+	table := m.loadTable()
+	if table.prev != nil {
+		return table, nil
+	}
+	return table, table.shards[hash&table.mask]
+}
+
+// written reports whether a write to the shard returned by unlocked with table is kept.
+// Otherwise a resize may have moved the shard before the write, which must be done again.
+func (m *SharedMap) written(table *sharedTable, shard *sharedShard) bool {
+	if m.loadTable() == table {
+		// A resize starting from now on moves the write with the shard.
+		return true
+	}
+
+	// A resize started meanwhile, the write is moved unless the shard was moved first.
+	return !shard.isMoved()
+}
+
+// locked calls fn with the shard holding the key of hash, locked for reading.
+func (m *SharedMap) locked(hash uint64, fn func(shard *SharedBlockMap)) {
+	shard := m.acquire(hash)
+	defer shard.release()
+
+	fn(shard.m)
+}
+
+// acquire returns the shard holding the key of hash, locked for reading.
+// The shard must be released after use.
+func (m *SharedMap) acquire(hash uint64) *sharedShard {
+	for {
+		table := m.loadTable()
+		// While resizing, keys stay in the previous table until their shard is moved.
+		if prev := table.prev; prev != nil {
+			if shard := prev.shards[hash&prev.mask]; shard.tryAcquire() {
+				return shard
+			}
+		}
+		if shard := table.shards[hash&table.mask]; shard.tryAcquire() {
+			return shard
+		}
+		// The table was replaced by a resize meanwhile, retry with the new one.
+	}
+}
+
+// ShardBlockSize returns the number of segments.
+func (m *SharedMap) ShardBlockSize() int {
+	return len(m.loadTable().shards)
+}
+
+// Resize changes the number of segments to shardBlockSize rounded up to a power of 2.
+//
+// The elements are moved one segment at a time, only the keys of the segment being moved
+// are blocked meanwhile. Resize returns once all elements are moved,
+// concurrent calls of Resize are serialized.
+// Resize must not be called from the functions passed to the map.
+func (m *SharedMap) Resize(shardBlockSize int) {
+	if shardBlockSize <= 0 {
+		panic("error")
+	}
+
+	m.resizeMu.Lock()
+	m.resizeLocked(getShardBlockSize(uint32(shardBlockSize)))
+	m.resizeMu.Unlock()
+}
+
+func (m *SharedMap) resizeLocked(shardBlockSize uint32) {
+	prev := m.loadTable()
+	if uint32(len(prev.shards)) == shardBlockSize {
+		return
+	}
+
+	table := newSharedTable(shardBlockSize, prev)
+	m.storeTable(table)
+
+	for _, shard := range prev.shards {
+		shard.mu.Lock()
+		shard.m.Range(func(key, value interface{}) bool {
+			table.shards[m.hasher(key.(string))&table.mask].m.Store(key, value)
+			return true
+		})
+		shard.moved = true
+		shard.mu.Unlock()
+	}
+
+	m.storeTable(&sharedTable{shards: table.shards, mask: table.mask})
+}
+
+// wrote records n writes which may have added elements, and grows the map if necessary.
+func (m *SharedMap) wrote(n int) {
+	if m.autoGrow <= 0 {
+		return
+	}
+	writes := atomic.AddUint32(&m.writes, uint32(n))
+	if writes/autoGrowInterval == (writes-uint32(n))/autoGrowInterval {
+		// No interval was completed.
+		return
+	}
+
+	table := m.loadTable()
+	if len(table.shards) >= maxShardBlockSize || table.len() <= m.autoGrow*len(table.shards) {
+		return
+	}
+
+	go m.grow()
+}
+
+func (m *SharedMap) grow() {
+	if !m.resizeMu.TryLock() {
+		// Another resize is in progress.
+		return
+	}
+	defer m.resizeMu.Unlock()
+
+	table := m.loadTable()
+	if len(table.shards) < maxShardBlockSize && table.len() > m.autoGrow*len(table.shards) {
+		m.resizeLocked(uint32(len(table.shards)) * 2)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedMap_Resize(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(4))
+	for i := 0; i < 1000; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+
+	for _, size := range []int{64, 2, 5, 8} {
+		sharedMap.Resize(size)
+		assert.Equal(t, int(getShardBlockSize(uint32(size))), sharedMap.ShardBlockSize())
+		assert.Equal(t, 1000, sharedMap.Len())
+		for i := 0; i < 1000; i++ {
+			v, ok := sharedMap.Load(strconv.Itoa(i))
+			assert.True(t, ok)
+			assert.Equal(t, i, v)
+		}
+	}
+
+	assert.Panics(t, func() {
+		sharedMap.Resize(0)
+	})
+}
+
+func TestSharedMap_ResizeConcurrent(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(2))
+	for i := 0; i < 1000; i++ {
+		sharedMap.Store("stale"+strconv.Itoa(i), i)
+	}
+
+	done := make(chan struct{})
+	var resizeWait sync.WaitGroup
+	resizeWait.Add(1)
+	go func() {
+		defer resizeWait.Done()
+		for size := 4; ; size *= 2 {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if size > 256 {
+				size = 2
+			}
+			sharedMap.Resize(size)
+		}
+	}()
+
+	var wait sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wait.Add(1)
+		go func(g int) {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(g) + "-" + strconv.Itoa(i)
+				sharedMap.Store(key, i)
+				v, ok := sharedMap.Load(key)
+				assert.True(t, ok)
+				assert.Equal(t, i, v)
+				if i%2 == 0 {
+					sharedMap.Delete(key)
+				}
+				if g == 0 {
+					sharedMap.Delete("stale" + strconv.Itoa(i))
+				}
+			}
+		}(g)
+	}
+	wait.Wait()
+	close(done)
+	resizeWait.Wait()
+
+	assert.Equal(t, 2000, sharedMap.Len())
+	assert.Len(t, sharedMap.Snapshot(), 2000)
+}
+
+func TestSharedMap_RangeWhileResizing(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(2))
+	for i := 0; i < 10000; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		sharedMap.Resize(1024)
+	}()
+
+	seen := map[interface{}]bool{}
+	sharedMap.Range(func(key, value interface{}) bool {
+		assert.False(t, seen[key], "key %v visited twice", key)
+		seen[key] = true
+		return true
+	})
+	wait.Wait()
+
+	assert.Len(t, seen, 10000)
+}
+
+func TestSharedMap_AutoGrow(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(2), WithAutoGrow(100))
+	for i := 0; i < 4096; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+
+	assert.Eventually(t, func() bool {
+		return sharedMap.ShardBlockSize() > 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 4096, sharedMap.Len())
+}
+
+func TestSharedMap_PanicInCallback(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(2))
+	sharedMap.Store("present", 1)
+	assert.Panics(t, func() {
+		sharedMap.ComputeIfAbsent("absent", func(key string) interface{} {
+			panic("compute")
+		})
+	})
+	assert.Panics(t, func() {
+		sharedMap.ComputeIfPresent("present", func(key string, value interface{}) interface{} {
+			panic("compute")
+		})
+	})
+
+	// The shards were released.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sharedMap.Resize(8)
+		sharedMap.SnapshotShards(func(snapshot map[string]interface{}) bool {
+			return true
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "a shard is still locked")
+	}
+	assert.Equal(t, 8, sharedMap.ShardBlockSize())
+}
+
+func TestSharedMap_BulkEventsWhileResizing(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(2))
+	events, fn := recordEvents()
+	sharedMap.Watch(fn)
+
+	done := make(chan struct{})
+	var resizeWait sync.WaitGroup
+	resizeWait.Add(1)
+	go func() {
+		defer resizeWait.Done()
+		for size := 4; ; size *= 2 {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if size > 256 {
+				size = 2
+			}
+			sharedMap.Resize(size)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		data := make(map[string]interface{}, 10)
+		keys := make([]string, 0, 10)
+		for j := 0; j < 10; j++ {
+			key := strconv.Itoa(i*10 + j)
+			data[key] = i
+			keys = append(keys, key)
+		}
+		sharedMap.MStore(data)
+		assert.Equal(t, data, sharedMap.MLoad(keys))
+		if i%2 == 0 {
+			sharedMap.MDelete(keys)
+		}
+	}
+	close(done)
+	resizeWait.Wait()
+
+	assert.Equal(t, 500, sharedMap.Len())
+	counts := map[EventType]int{}
+	for _, event := range *events {
+		counts[event.Type]++
+	}
+	assert.Equal(t, 1000, counts[EventStore])
+	assert.Equal(t, 500, counts[EventDelete])
+}
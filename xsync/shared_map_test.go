@@ -21,6 +21,8 @@ import (
 	"hash/fnv"
 	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -37,8 +39,8 @@ func TestMapCreation(t *testing.T) {
 }
 func TestWithShardBlockSize(t *testing.T) {
 	sharedMap := NewSharedMap(WithShardBlockSize(10))
-	assert.EqualValues(t, uint32(16), sharedMap.shardBlockSize)
-	assert.EqualValues(t, uint32(16), sharedMap.n+1)
+	assert.EqualValues(t, 16, sharedMap.ShardBlockSize())
+	assert.EqualValues(t, uint64(16), sharedMap.loadTable().mask+1)
 }
 
 //
@@ -296,3 +298,179 @@ func TestSharedMap_ComputeIfPresent(t *testing.T) {
 	assert.False(t, loaded)
 	assert.EqualValues(t, nil, actual)
 }
+
+func TestSharedMap_Len(t *testing.T) {
+	sharedMap := NewSharedMap()
+	for i := 0; i < 100; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+	sharedMap.Delete("0")
+
+	assert.Equal(t, 99, sharedMap.Len())
+	assert.Len(t, sharedMap.Keys(), 99)
+
+	snapshot := sharedMap.Snapshot()
+	assert.Len(t, snapshot, 99)
+	assert.Equal(t, 1, snapshot["1"])
+
+	sharedMap.Clear()
+	assert.Equal(t, 0, sharedMap.Len())
+}
+
+func TestSharedMap_WithHasher(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(4), WithHasher(func(key string) uint64 {
+		return 0
+	}))
+	for i := 0; i < 10; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+
+	stats := sharedMap.ShardStats()
+	assert.Equal(t, []int{10, 0, 0, 0}, stats.Lengths)
+	assert.Equal(t, 0, stats.Min)
+	assert.Equal(t, 10, stats.Max)
+	assert.Equal(t, 2.5, stats.Mean)
+	assert.Equal(t, 4.0, stats.Skew)
+
+	v, ok := sharedMap.Load("1")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+}
+
+func TestNewMapHasher(t *testing.T) {
+	hasher := NewMapHasher()
+	assert.Equal(t, hasher("key"), hasher("key"))
+
+	sharedMap := NewSharedMap(WithShardBlockSize(4), WithHasher(hasher))
+	for i := 0; i < 1000; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+	stats := sharedMap.ShardStats()
+	assert.Equal(t, 1000, sharedMap.Len())
+	assert.Less(t, stats.Skew, 1.5)
+	assert.Greater(t, stats.Min, 0)
+}
+
+func TestSharedMap_ShardStatsEmpty(t *testing.T) {
+	stats := NewSharedMap(WithShardBlockSize(2)).ShardStats()
+	assert.Equal(t, []int{0, 0}, stats.Lengths)
+	assert.Equal(t, 0.0, stats.Skew)
+}
+
+func TestSharedMap_ParallelRange(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(8))
+	for i := 0; i < 1000; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+
+	var lock sync.Mutex
+	seen := map[interface{}]bool{}
+	sharedMap.ParallelRange(func(key, value interface{}) bool {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.False(t, seen[key])
+		seen[key] = true
+		return true
+	}, 4)
+	assert.Len(t, seen, 1000)
+
+	var count int32
+	sharedMap.ParallelRange(func(key, value interface{}) bool {
+		atomic.AddInt32(&count, 1)
+		return false
+	}, 2)
+	assert.LessOrEqual(t, atomic.LoadInt32(&count), int32(2))
+
+	assert.Panics(t, func() {
+		sharedMap.ParallelRange(func(key, value interface{}) bool {
+			return true
+		}, 0)
+	})
+}
+
+func TestSharedMap_MLoadAndMDelete(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(4))
+	data := map[string]interface{}{}
+	for i := 0; i < 100; i++ {
+		data[strconv.Itoa(i)] = i
+	}
+	sharedMap.MStore(data)
+	assert.Equal(t, 100, sharedMap.Len())
+
+	values := sharedMap.MLoad([]string{"1", "2", "missing"})
+	assert.Equal(t, map[string]interface{}{"1": 1, "2": 2}, values)
+
+	sharedMap.MDelete([]string{"1", "2", "missing"})
+	assert.Equal(t, 98, sharedMap.Len())
+	assert.False(t, sharedMap.Has("1"))
+}
+
+func TestSharedMap_DeleteIf(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(4))
+	for i := 0; i < 100; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+
+	deleted := sharedMap.DeleteIf(func(key string, value interface{}) bool {
+		return value.(int)%2 == 0
+	})
+	assert.Equal(t, 50, deleted)
+	assert.Equal(t, 50, sharedMap.Len())
+	sharedMap.Range(func(key, value interface{}) bool {
+		assert.Equal(t, 1, value.(int)%2)
+		return true
+	})
+}
+
+func TestSharedMap_BulkWhileResizing(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(2))
+	keys := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, strconv.Itoa(i))
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		sharedMap.Resize(256)
+		sharedMap.Resize(4)
+	}()
+
+	assert.Len(t, sharedMap.MLoad(keys), 1000)
+	deleted := sharedMap.DeleteIf(func(key string, value interface{}) bool {
+		return value.(int) < 500
+	})
+	sharedMap.MDelete(keys[500:600])
+	wait.Wait()
+
+	assert.Equal(t, 500, deleted)
+	assert.Equal(t, 400, sharedMap.Len())
+}
+
+func TestSharedMap_SnapshotShards(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(4))
+	for i := 0; i < 100; i++ {
+		sharedMap.Store(strconv.Itoa(i), i)
+	}
+
+	merged := map[string]interface{}{}
+	shards := 0
+	sharedMap.SnapshotShards(func(snapshot map[string]interface{}) bool {
+		shards++
+		for key, value := range snapshot {
+			merged[key] = value
+		}
+		return true
+	})
+	assert.Equal(t, 4, shards)
+	assert.Equal(t, sharedMap.Snapshot(), merged)
+
+	shards = 0
+	sharedMap.SnapshotShards(func(snapshot map[string]interface{}) bool {
+		shards++
+		return false
+	})
+	assert.Equal(t, 1, shards)
+}
@@ -18,27 +18,50 @@ package xsync
 
 import (
 	"runtime"
+	"sync"
 	"sync/atomic"
 )
 
-const maxBackoff = 16
+const (
+	maxBackoff = 16
 
-// Spinlock represents a spin lock.
-type Spinlock struct {
-	lock uint32
-}
+	rwWriterLocked  = 1 << 31
+	rwWriterPending = 1 << 30
+)
+
+var (
+	_ sync.Locker = (*Spinlock)(nil)
+	_ sync.Locker = (*RWSpinlock)(nil)
+	_ sync.Locker = (*TicketSpinlock)(nil)
+)
+
+type (
+	// Spinlock represents a spin lock.
+	Spinlock struct {
+		lock uint32
+	}
+
+	// RWSpinlock represents a reader/writer spin lock, for read-mostly short critical sections.
+	// A waiting writer blocks the new readers, so readers cannot starve writers.
+	RWSpinlock struct {
+		// state holds the rwWriterLocked and rwWriterPending flags and the number of readers.
+		state uint32
+	}
+
+	// TicketSpinlock represents a fair spin lock, goroutines acquire it in FIFO order.
+	TicketSpinlock struct {
+		next    uint32
+		serving uint32
+	}
+
+	rwSpinlockReader RWSpinlock
+)
 
 // Lock locks the Spinlock.
 func (lock *Spinlock) Lock() {
 	backoff := 1
 	for !lock.TryLock() {
-		// Leverage the exponential backoff algorithm, see https://en.wikipedia.org/wiki/Exponential_backoff.
-		for i := 0; i < backoff; i++ {
-			runtime.Gosched()
-		}
-		if backoff < maxBackoff {
-			backoff <<= 1
-		}
+		backoff = spin(backoff)
 	}
 }
 
@@ -51,3 +74,109 @@ func (lock *Spinlock) TryLock() bool {
 func (lock *Spinlock) Unlock() {
 	atomic.StoreUint32(&lock.lock, 0)
 }
+
+// Lock locks the RWSpinlock for writing.
+func (lock *RWSpinlock) Lock() {
+	backoff := 1
+	for !lock.TryLock() {
+		// Announce the writer so that the new readers wait.
+		for {
+			state := atomic.LoadUint32(&lock.state)
+			if state&rwWriterPending != 0 || atomic.CompareAndSwapUint32(&lock.state, state, state|rwWriterPending) {
+				break
+			}
+		}
+		backoff = spin(backoff)
+	}
+}
+
+// TryLock tries to lock the RWSpinlock for writing.
+func (lock *RWSpinlock) TryLock() bool {
+	state := atomic.LoadUint32(&lock.state)
+	// Taking the lock clears the pending flag, the other waiting writers set it again.
+	return state&^rwWriterPending == 0 && atomic.CompareAndSwapUint32(&lock.state, state, rwWriterLocked)
+}
+
+// Unlock unlocks the RWSpinlock for writing.
+func (lock *RWSpinlock) Unlock() {
+	for {
+		state := atomic.LoadUint32(&lock.state)
+		if state&rwWriterLocked == 0 {
+			panic("unlock of unlocked RWSpinlock")
+		}
+		if atomic.CompareAndSwapUint32(&lock.state, state, state&^rwWriterLocked) {
+			return
+		}
+	}
+}
+
+// RLock locks the RWSpinlock for reading.
+func (lock *RWSpinlock) RLock() {
+	backoff := 1
+	for !lock.TryRLock() {
+		backoff = spin(backoff)
+	}
+}
+
+// TryRLock tries to lock the RWSpinlock for reading.
+func (lock *RWSpinlock) TryRLock() bool {
+	for {
+		state := atomic.LoadUint32(&lock.state)
+		if state&(rwWriterLocked|rwWriterPending) != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(&lock.state, state, state+1) {
+			return true
+		}
+	}
+}
+
+// RUnlock unlocks the RWSpinlock for reading.
+func (lock *RWSpinlock) RUnlock() {
+	atomic.AddUint32(&lock.state, ^uint32(0))
+}
+
+// RLocker returns a sync.Locker that locks the RWSpinlock for reading.
+func (lock *RWSpinlock) RLocker() sync.Locker {
+	return (*rwSpinlockReader)(lock)
+}
+
+func (r *rwSpinlockReader) Lock() {
+	(*RWSpinlock)(r).RLock()
+}
+
+func (r *rwSpinlockReader) Unlock() {
+	(*RWSpinlock)(r).RUnlock()
+}
+
+// Lock locks the TicketSpinlock.
+func (lock *TicketSpinlock) Lock() {
+	ticket := atomic.AddUint32(&lock.next, 1) - 1
+	backoff := 1
+	for atomic.LoadUint32(&lock.serving) != ticket {
+		backoff = spin(backoff)
+	}
+}
+
+// TryLock tries to lock the TicketSpinlock, it fails if the lock is held or awaited.
+func (lock *TicketSpinlock) TryLock() bool {
+	serving := atomic.LoadUint32(&lock.serving)
+	return atomic.CompareAndSwapUint32(&lock.next, serving, serving+1)
+}
+
+// Unlock unlocks the TicketSpinlock.
+func (lock *TicketSpinlock) Unlock() {
+	atomic.AddUint32(&lock.serving, 1)
+}
+
+// spin yields the processor backoff times and returns the next backoff.
+func spin(backoff int) int {
+	// Leverage the exponential backoff algorithm, see https://en.wikipedia.org/wiki/Exponential_backoff.
+	for i := 0; i < backoff; i++ {
+		runtime.Gosched()
+	}
+	if backoff < maxBackoff {
+		backoff <<= 1
+	}
+	return backoff
+}
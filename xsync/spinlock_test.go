@@ -82,3 +82,187 @@ func TestSpinLock_TryLock(t *testing.T) {
 	wait.Wait()
 	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
 }
+
+func testMutualExclusion(t *testing.T, lock sync.Locker) {
+	var (
+		wait  sync.WaitGroup
+		count int
+	)
+	for g := 0; g < 8; g++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				lock.Lock()
+				count++
+				lock.Unlock()
+			}
+		}()
+	}
+	wait.Wait()
+	assert.Equal(t, 8000, count)
+}
+
+func TestRWSpinlock(t *testing.T) {
+	var lock RWSpinlock
+	assert.True(t, lock.TryRLock())
+	assert.True(t, lock.TryRLock())
+	assert.False(t, lock.TryLock())
+	lock.RUnlock()
+	lock.RUnlock()
+
+	assert.True(t, lock.TryLock())
+	assert.False(t, lock.TryRLock())
+	assert.False(t, lock.TryLock())
+	lock.Unlock()
+	assert.Panics(t, lock.Unlock)
+
+	testMutualExclusion(t, &lock)
+}
+
+func TestRWSpinlock_WriterPreference(t *testing.T) {
+	var lock RWSpinlock
+	lock.RLock()
+
+	locked := make(chan struct{})
+	go func() {
+		lock.Lock()
+		close(locked)
+		lock.Unlock()
+	}()
+
+	// The waiting writer blocks the new readers.
+	assert.Eventually(t, func() bool {
+		return !lock.TryRLock()
+	}, time.Second, time.Millisecond)
+	lock.RUnlock()
+	<-locked
+
+	assert.True(t, lock.TryRLock())
+	lock.RUnlock()
+}
+
+func TestRWSpinlock_Readers(t *testing.T) {
+	var (
+		lock    RWSpinlock
+		wait    sync.WaitGroup
+		value   int
+		readers int32
+	)
+	reader := lock.RLocker()
+	for g := 0; g < 8; g++ {
+		wait.Add(2)
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 1000; i++ {
+				reader.Lock()
+				atomic.AddInt32(&readers, 1)
+				_ = value
+				atomic.AddInt32(&readers, -1)
+				reader.Unlock()
+			}
+		}()
+		go func() {
+			defer wait.Done()
+			for i := 0; i < 100; i++ {
+				lock.Lock()
+				assert.Equal(t, int32(0), atomic.LoadInt32(&readers))
+				value++
+				lock.Unlock()
+			}
+		}()
+	}
+	wait.Wait()
+	assert.Equal(t, 800, value)
+}
+
+func TestTicketSpinlock(t *testing.T) {
+	var lock TicketSpinlock
+	assert.True(t, lock.TryLock())
+	assert.False(t, lock.TryLock())
+	lock.Unlock()
+	assert.True(t, lock.TryLock())
+	lock.Unlock()
+
+	testMutualExclusion(t, &lock)
+}
+
+func TestTicketSpinlock_FIFO(t *testing.T) {
+	var lock TicketSpinlock
+	lock.Lock()
+
+	var (
+		order []int
+		wait  sync.WaitGroup
+	)
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			lock.Lock()
+			order = append(order, i)
+			lock.Unlock()
+		}(i)
+		// Wait for the goroutine to take its ticket.
+		for atomic.LoadUint32(&lock.next) != uint32(i+2) {
+			runtime.Gosched()
+		}
+	}
+	lock.Unlock()
+	wait.Wait()
+	assert.Equal(t, []int{0, 1, 2, 3}, order)
+}
+
+func benchmarkLocker(b *testing.B, lock sync.Locker) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lock.Lock()
+			lock.Unlock()
+		}
+	})
+}
+
+func BenchmarkLockers(b *testing.B) {
+	b.Run("sync.Mutex", func(b *testing.B) {
+		benchmarkLocker(b, &sync.Mutex{})
+	})
+	b.Run("Spinlock", func(b *testing.B) {
+		benchmarkLocker(b, &Spinlock{})
+	})
+	b.Run("TicketSpinlock", func(b *testing.B) {
+		benchmarkLocker(b, &TicketSpinlock{})
+	})
+	b.Run("RWSpinlock", func(b *testing.B) {
+		benchmarkLocker(b, &RWSpinlock{})
+	})
+}
+
+func benchmarkReadMostly(b *testing.B, lock, reader sync.Locker) {
+	var i int64
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if atomic.AddInt64(&i, 1)%100 == 0 {
+				lock.Lock()
+				lock.Unlock()
+			} else {
+				reader.Lock()
+				reader.Unlock()
+			}
+		}
+	})
+}
+
+func BenchmarkReadMostlyLockers(b *testing.B) {
+	b.Run("sync.Mutex", func(b *testing.B) {
+		var lock sync.Mutex
+		benchmarkReadMostly(b, &lock, &lock)
+	})
+	b.Run("sync.RWMutex", func(b *testing.B) {
+		var lock sync.RWMutex
+		benchmarkReadMostly(b, &lock, lock.RLocker())
+	})
+	b.Run("RWSpinlock", func(b *testing.B) {
+		var lock RWSpinlock
+		benchmarkReadMostly(b, &lock, lock.RLocker())
+	})
+}
@@ -0,0 +1,377 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+type (
+	// TypedMap is a Map with typed keys and values.
+	// It has the same lock-free read path and compute semantics as Map,
+	// but doesn't need type assertions and boxes no value into an interface{}.
+	TypedMap[K comparable, V any] struct {
+		mu sync.Mutex
+
+		// read contains the portion of the map's contents that are safe for
+		// concurrent access (with or without mu held), see Map.read.
+		read atomic.Value // typedReadOnly[K, V]
+
+		// dirty contains the portion of the map's contents that require mu to be
+		// held, see Map.dirty.
+		dirty map[K]*typedEntry[V]
+
+		// misses counts the number of loads since the read map was last updated that
+		// needed to lock mu to determine whether the key was present, see Map.misses.
+		misses int
+	}
+	// typedReadOnly is an immutable struct stored atomically in the TypedMap.read field.
+	typedReadOnly[K comparable, V any] struct {
+		m       map[K]*typedEntry[V]
+		amended bool // true if the dirty map contains some key not in m.
+	}
+	// A typedEntry is a slot in the map corresponding to a particular key.
+	typedEntry[V any] struct {
+		// p points to the V value stored for the entry,
+		// nil and expunged have the same meaning as in entry.p.
+		p unsafe.Pointer // *V
+	}
+)
+
+func newTypedEntry[V any](v V) *typedEntry[V] {
+	return &typedEntry[V]{p: unsafe.Pointer(&v)}
+}
+
+func (m *TypedMap[K, V]) loadReadOnly() typedReadOnly[K, V] {
+	read, _ := m.read.Load().(typedReadOnly[K, V])
+	return read
+}
+
+// Load returns the value stored in the map for a key, or the zero value if no
+// value is present.
+// The ok result indicates whether value was found in the map.
+func (m *TypedMap[K, V]) Load(key K) (value V, ok bool) {
+	read := m.loadReadOnly()
+	e, ok := read.m[key]
+	if !ok && read.amended {
+		m.mu.Lock()
+		read = m.loadReadOnly()
+		e, ok = read.m[key]
+		if !ok && read.amended {
+			e, ok = m.dirty[key]
+			m.missLocked()
+		}
+		m.mu.Unlock()
+	}
+	if !ok {
+		return value, false
+	}
+	return e.load()
+}
+
+func (e *typedEntry[V]) load() (value V, ok bool) {
+	p := atomic.LoadPointer(&e.p)
+	if p == nil || p == expunged {
+		return value, false
+	}
+	return *(*V)(p), true
+}
+
+// Store sets the value for a key.
+func (m *TypedMap[K, V]) Store(key K, value V) {
+	read := m.loadReadOnly()
+	if e, ok := read.m[key]; ok && e.tryStore(&value) {
+		return
+	}
+
+	m.mu.Lock()
+	read = m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		if e.unexpungeLocked() {
+			m.dirty[key] = e
+		}
+		e.storeLocked(&value)
+	} else if e, ok := m.dirty[key]; ok {
+		e.storeLocked(&value)
+	} else {
+		if !read.amended {
+			m.dirtyLocked()
+			m.read.Store(typedReadOnly[K, V]{m: read.m, amended: true})
+		}
+		m.dirty[key] = newTypedEntry(value)
+	}
+	m.mu.Unlock()
+}
+
+func (e *typedEntry[V]) tryStore(v *V) bool {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == expunged {
+			return false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(v)) {
+			return true
+		}
+	}
+}
+
+func (e *typedEntry[V]) unexpungeLocked() (wasExpunged bool) {
+	return atomic.CompareAndSwapPointer(&e.p, expunged, nil)
+}
+
+func (e *typedEntry[V]) storeLocked(v *V) {
+	atomic.StorePointer(&e.p, unsafe.Pointer(v))
+}
+
+// LoadOrStore returns the existing value for the key if present.
+// Otherwise, it stores and returns the given value.
+// The loaded result is true if the value was loaded, false if stored.
+func (m *TypedMap[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool) {
+	return m.ComputeIfAbsent(key, func(K) V {
+		return value
+	})
+}
+
+// ComputeIfAbsent  if the value corresponding to the key does not exist,
+// use the recalculated value obtained by remappingFunction and save it as the value of the key,
+// otherwise return the value.
+func (m *TypedMap[K, V]) ComputeIfAbsent(key K, computeFunc func(key K) V) (actual V, loaded bool) {
+	read := m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		actual, loaded, ok := e.tryComputeIfAbsent(func() V {
+			return computeFunc(key)
+		})
+		if ok {
+			return actual, loaded
+		}
+	}
+
+	m.mu.Lock()
+	read = m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		if e.unexpungeLocked() {
+			m.dirty[key] = e
+		}
+		actual, loaded, _ = e.tryComputeIfAbsent(func() V {
+			return computeFunc(key)
+		})
+	} else if e, ok := m.dirty[key]; ok {
+		actual, loaded, _ = e.tryComputeIfAbsent(func() V {
+			return computeFunc(key)
+		})
+		m.missLocked()
+	} else {
+		if !read.amended {
+			m.dirtyLocked()
+			m.read.Store(typedReadOnly[K, V]{m: read.m, amended: true})
+		}
+		actual = computeFunc(key)
+		m.dirty[key] = newTypedEntry(actual)
+		loaded = false
+	}
+	m.mu.Unlock()
+	return actual, loaded
+}
+
+func (e *typedEntry[V]) tryComputeIfAbsent(computeFunc func() V) (actual V, loaded, ok bool) {
+	p := atomic.LoadPointer(&e.p)
+	if p == expunged {
+		return actual, false, false
+	}
+	if p != nil {
+		return *(*V)(p), true, true
+	}
+
+	v := computeFunc()
+	for {
+		if atomic.CompareAndSwapPointer(&e.p, nil, unsafe.Pointer(&v)) {
+			return v, false, true
+		}
+		p = atomic.LoadPointer(&e.p)
+		if p == expunged {
+			return actual, false, false
+		}
+		if p != nil {
+			return *(*V)(p), true, true
+		}
+	}
+}
+
+// ComputeIfPresent if the value corresponding to the key does not exist,
+// the zero value is returned, and if it exists, the value recalculated by remappingFunction is returned.
+func (m *TypedMap[K, V]) ComputeIfPresent(key K, computeFunc func(key K, value V) V) (actual V, exist bool) {
+	read := m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		actual, exist, ok := e.tryComputeIfPresent(func(value V) V {
+			return computeFunc(key, value)
+		})
+		if ok {
+			return actual, exist
+		}
+	}
+
+	m.mu.Lock()
+	read = m.loadReadOnly()
+	if e, ok := read.m[key]; ok {
+		if e.unexpungeLocked() {
+			m.dirty[key] = e
+		}
+		actual, exist, _ = e.tryComputeIfPresent(func(value V) V {
+			return computeFunc(key, value)
+		})
+	} else if e, ok := m.dirty[key]; ok {
+		actual, exist, _ = e.tryComputeIfPresent(func(value V) V {
+			return computeFunc(key, value)
+		})
+		m.missLocked()
+	}
+	m.mu.Unlock()
+	return actual, exist
+}
+
+// tryComputeIfPresent recomputes the value if it was changed concurrently,
+// so computeFunc may be called more than once.
+func (e *typedEntry[V]) tryComputeIfPresent(computeFunc func(value V) V) (actual V, exist, ok bool) {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == expunged {
+			return actual, false, false
+		}
+		if p == nil {
+			return actual, false, true
+		}
+
+		v := computeFunc(*(*V)(p))
+		if atomic.CompareAndSwapPointer(&e.p, p, unsafe.Pointer(&v)) {
+			return v, true, true
+		}
+	}
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if any.
+// The loaded result reports whether the key was present.
+func (m *TypedMap[K, V]) LoadAndDelete(key K) (value V, loaded bool) {
+	read := m.loadReadOnly()
+	e, ok := read.m[key]
+	if !ok && read.amended {
+		m.mu.Lock()
+		read = m.loadReadOnly()
+		e, ok = read.m[key]
+		if !ok && read.amended {
+			e, ok = m.dirty[key]
+			delete(m.dirty, key)
+			m.missLocked()
+		}
+		m.mu.Unlock()
+	}
+	if ok {
+		return e.delete()
+	}
+	return value, false
+}
+
+// Delete deletes the value for a key.
+func (m *TypedMap[K, V]) Delete(key K) {
+	m.LoadAndDelete(key)
+}
+
+// Clear clears all elements.
+func (m *TypedMap[K, V]) Clear() {
+	m.mu.Lock()
+	m.read.Store(typedReadOnly[K, V]{})
+	m.dirty = nil
+	m.misses = 0
+	m.mu.Unlock()
+}
+
+func (e *typedEntry[V]) delete() (value V, ok bool) {
+	for {
+		p := atomic.LoadPointer(&e.p)
+		if p == nil || p == expunged {
+			return value, false
+		}
+		if atomic.CompareAndSwapPointer(&e.p, p, nil) {
+			return *(*V)(p), true
+		}
+	}
+}
+
+// Range calls f sequentially for each key and value present in the map.
+// If f returns false, range stops the iteration.
+//
+// Range has the same consistency and complexity as Map.Range.
+func (m *TypedMap[K, V]) Range(f func(key K, value V) bool) {
+	read := m.loadReadOnly()
+	if read.amended {
+		m.mu.Lock()
+		read = m.loadReadOnly()
+		if read.amended {
+			read = typedReadOnly[K, V]{m: m.dirty}
+			m.read.Store(read)
+			m.dirty = nil
+			m.misses = 0
+		}
+		m.mu.Unlock()
+	}
+
+	for k, e := range read.m {
+		v, ok := e.load()
+		if !ok {
+			continue
+		}
+		if !f(k, v) {
+			break
+		}
+	}
+}
+
+func (m *TypedMap[K, V]) missLocked() {
+	m.misses++
+	if m.misses < len(m.dirty) {
+		return
+	}
+	m.read.Store(typedReadOnly[K, V]{m: m.dirty})
+	m.dirty = nil
+	m.misses = 0
+}
+
+func (m *TypedMap[K, V]) dirtyLocked() {
+	if m.dirty != nil {
+		return
+	}
+
+	read := m.loadReadOnly()
+	m.dirty = make(map[K]*typedEntry[V], len(read.m))
+	for k, e := range read.m {
+		if !e.tryExpungeLocked() {
+			m.dirty[k] = e
+		}
+	}
+}
+
+func (e *typedEntry[V]) tryExpungeLocked() (isExpunged bool) {
+	p := atomic.LoadPointer(&e.p)
+	for p == nil {
+		if atomic.CompareAndSwapPointer(&e.p, nil, expunged) {
+			return true
+		}
+		p = atomic.LoadPointer(&e.p)
+	}
+	return p == expunged
+}
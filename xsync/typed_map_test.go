@@ -0,0 +1,169 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _ mapInterface = (*typedMapAdapter)(nil)
+
+// typedMapAdapter adapts a TypedMap to mapInterface, the keys generated by mapCall are strings.
+type typedMapAdapter struct {
+	m TypedMap[string, interface{}]
+}
+
+func (a *typedMapAdapter) Load(key interface{}) (interface{}, bool) {
+	return a.m.Load(key.(string))
+}
+
+func (a *typedMapAdapter) Store(key, value interface{}) {
+	a.m.Store(key.(string), value)
+}
+
+func (a *typedMapAdapter) LoadOrStore(key, value interface{}) (actual interface{}, loaded bool) {
+	return a.m.LoadOrStore(key.(string), value)
+}
+
+func (a *typedMapAdapter) LoadAndDelete(key interface{}) (value interface{}, loaded bool) {
+	return a.m.LoadAndDelete(key.(string))
+}
+
+func (a *typedMapAdapter) Delete(key interface{}) {
+	a.m.Delete(key.(string))
+}
+
+func (a *typedMapAdapter) Range(f func(key, value interface{}) (shouldContinue bool)) {
+	a.m.Range(func(key string, value interface{}) bool {
+		return f(key, value)
+	})
+}
+
+func (a *typedMapAdapter) ComputeIfAbsent(key interface{}, computeFunc func(key interface{}) interface{}) (actual interface{}, loaded bool) {
+	return a.m.ComputeIfAbsent(key.(string), func(key string) interface{} {
+		return computeFunc(key)
+	})
+}
+
+func (a *typedMapAdapter) ComputeIfPresent(key interface{}, computeFunc func(key, value interface{}) interface{}) (actual interface{}, exist bool) {
+	return a.m.ComputeIfPresent(key.(string), func(key string, value interface{}) interface{} {
+		return computeFunc(key, value)
+	})
+}
+
+func applyTypedMap(calls []mapCall) ([]mapResult, map[interface{}]interface{}) {
+	return applyCalls(new(typedMapAdapter), calls)
+}
+
+func TestTypedMapMatchesRWMutex(t *testing.T) {
+	if err := quick.CheckEqual(applyTypedMap, applyRWMutexMap, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTypedMap(t *testing.T) {
+	var m TypedMap[string, int]
+
+	v, ok := m.Load("a")
+	assert.False(t, ok)
+	assert.Equal(t, 0, v)
+
+	m.Store("a", 1)
+	v, ok = m.Load("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	actual, loaded := m.LoadOrStore("a", 2)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, actual)
+
+	actual, loaded = m.ComputeIfAbsent("b", func(key string) int {
+		return len(key) + 1
+	})
+	assert.False(t, loaded)
+	assert.Equal(t, 2, actual)
+
+	actual, exist := m.ComputeIfPresent("b", func(key string, value int) int {
+		return value * 10
+	})
+	assert.True(t, exist)
+	assert.Equal(t, 20, actual)
+
+	_, exist = m.ComputeIfPresent("c", func(key string, value int) int {
+		return value
+	})
+	assert.False(t, exist)
+
+	v, loaded = m.LoadAndDelete("a")
+	assert.True(t, loaded)
+	assert.Equal(t, 1, v)
+
+	count := 0
+	m.Range(func(key string, value int) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 1, count)
+
+	m.Clear()
+	_, ok = m.Load("b")
+	assert.False(t, ok)
+}
+
+func TestTypedMapConcurrentComputeIfPresent(t *testing.T) {
+	var m TypedMap[string, int]
+	m.Store("counter", 0)
+
+	var wait sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				m.ComputeIfPresent("counter", func(key string, value int) int {
+					return value + 1
+				})
+				m.Store(strconv.Itoa(j), j)
+			}
+		}()
+	}
+	wait.Wait()
+
+	v, _ := m.Load("counter")
+	assert.Equal(t, 1000, v)
+}
+
+func BenchmarkTypedMapLoadMostlyHits(b *testing.B) {
+	const hits, misses = 1023, 1
+
+	var m TypedMap[int, int]
+	for i := 0; i < hits; i++ {
+		m.LoadOrStore(i, i)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			m.Load(i % (hits + misses))
+		}
+	})
+}
@@ -0,0 +1,231 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// EventStore means a value was stored for Event.Key.
+	EventStore EventType = iota + 1
+	// EventDelete means the value Event.Value of Event.Key was deleted.
+	EventDelete
+	// EventClear means all elements were removed, Event.Key and Event.Value are nil.
+	EventClear
+)
+
+type (
+	// EventType is the type of mutation.
+	EventType int
+	// Event describes a mutation of a Map or SharedMap.
+	Event struct {
+		Type  EventType
+		Key   interface{}
+		Value interface{}
+	}
+
+	// WatchOption customizes a watch.
+	WatchOption func(*watchOptions)
+
+	watchOptions struct {
+		prefix    string
+		hasPrefix bool
+	}
+
+	// watchers is a copy-on-write list of watchers, the zero value is ready to use.
+	watchers struct {
+		mu   sync.Mutex
+		list unsafe.Pointer // *[]*watcher
+	}
+
+	watcher struct {
+		fn      func(event Event)
+		options watchOptions
+	}
+
+	// chanWatcher delivers events to a channel until it's cancelled.
+	chanWatcher struct {
+		mu     sync.RWMutex
+		events chan Event
+		done   chan struct{}
+		closed bool
+		once   sync.Once
+	}
+)
+
+// WithKeyPrefix returns a WatchOption that only delivers the events of the string keys
+// starting with prefix, and EventClear.
+func WithKeyPrefix(prefix string) WatchOption {
+	return func(o *watchOptions) {
+		o.prefix = prefix
+		o.hasPrefix = true
+	}
+}
+
+// watch registers fn and returns a function which unregisters it.
+func (w *watchers) watch(fn func(event Event), opts ...WatchOption) (cancel func()) {
+	wt := &watcher{fn: fn}
+	for _, opt := range opts {
+		opt(&wt.options)
+	}
+
+	w.mu.Lock()
+	list := w.load()
+	next := make([]*watcher, len(list), len(list)+1)
+	copy(next, list)
+	next = append(next, wt)
+	atomic.StorePointer(&w.list, unsafe.Pointer(&next))
+	w.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			w.remove(wt)
+		})
+	}
+}
+
+// watchChan registers a watcher delivering the events to a channel of the given buffer size.
+func (w *watchers) watchChan(size int, opts ...WatchOption) (events <-chan Event, cancel func()) {
+	cw := &chanWatcher{
+		events: make(chan Event, size),
+		done:   make(chan struct{}),
+	}
+	unwatch := w.watch(cw.send, opts...)
+	return cw.events, func() {
+		unwatch()
+		cw.close()
+	}
+}
+
+func (w *watchers) remove(wt *watcher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	list := w.load()
+	next := make([]*watcher, 0, len(list))
+	for _, other := range list {
+		if other != wt {
+			next = append(next, other)
+		}
+	}
+	if len(next) == 0 {
+		atomic.StorePointer(&w.list, nil)
+		return
+	}
+	atomic.StorePointer(&w.list, unsafe.Pointer(&next))
+}
+
+func (w *watchers) load() []*watcher {
+	list := (*[]*watcher)(atomic.LoadPointer(&w.list))
+	if list == nil {
+		return nil
+	}
+	return *list
+}
+
+// watched reports whether there is any watcher.
+func (w *watchers) watched() bool {
+	return atomic.LoadPointer(&w.list) != nil
+}
+
+func (w *watchers) notify(eventType EventType, key, value interface{}) {
+	list := w.load()
+	if len(list) == 0 {
+		return
+	}
+
+	event := Event{Type: eventType, Key: key, Value: value}
+	for _, wt := range list {
+		if wt.match(event) {
+			wt.fn(event)
+		}
+	}
+}
+
+func (wt *watcher) match(event Event) bool {
+	if !wt.options.hasPrefix || event.Type == EventClear {
+		return true
+	}
+
+	key, ok := event.Key.(string)
+	return ok && strings.HasPrefix(key, wt.options.prefix)
+}
+
+func (cw *chanWatcher) send(event Event) {
+	cw.mu.RLock()
+	defer cw.mu.RUnlock()
+	if cw.closed {
+		return
+	}
+
+	select {
+	case cw.events <- event:
+	case <-cw.done:
+	}
+}
+
+func (cw *chanWatcher) close() {
+	cw.once.Do(func() {
+		// Unblock the pending sends before waiting for them.
+		close(cw.done)
+		cw.mu.Lock()
+		cw.closed = true
+		close(cw.events)
+		cw.mu.Unlock()
+	})
+}
+
+// Watch calls fn after every mutation of the map, and returns a function which stops the watch.
+//
+// fn is called synchronously by the goroutine which mutated the map, after the mutation.
+// Events of concurrent mutations of the same key may be delivered in any order.
+func (m *Map) Watch(fn func(event Event), opts ...WatchOption) (cancel func()) {
+	return m.watchers.watch(fn, opts...)
+}
+
+// WatchChan returns a channel receiving an Event after every mutation of the map,
+// and a function which stops the watch and closes the channel.
+//
+// Mutations block while the channel is full, the channel must be drained until
+// the watch is stopped.
+func (m *Map) WatchChan(size int, opts ...WatchOption) (events <-chan Event, cancel func()) {
+	return m.watchers.watchChan(size, opts...)
+}
+
+// Watch calls fn after every mutation made through the methods of the SharedMap,
+// and returns a function which stops the watch.
+// Mutations of the shards returned by GetShard are not watched, nor are the moves of a resize.
+//
+// fn is called synchronously by the goroutine which mutated the map, after the mutation.
+// Events of concurrent mutations of the same key may be delivered in any order.
+func (m *SharedMap) Watch(fn func(event Event), opts ...WatchOption) (cancel func()) {
+	return m.watchers.watch(fn, opts...)
+}
+
+// WatchChan returns a channel receiving an Event after every mutation made through the methods
+// of the SharedMap, and a function which stops the watch and closes the channel.
+//
+// Mutations block while the channel is full, the channel must be drained until
+// the watch is stopped.
+func (m *SharedMap) WatchChan(size int, opts ...WatchOption) (events <-chan Event, cancel func()) {
+	return m.watchers.watchChan(size, opts...)
+}
@@ -0,0 +1,187 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xsync
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func recordEvents() (*[]Event, func(event Event)) {
+	var (
+		lock   sync.Mutex
+		events []Event
+	)
+	return &events, func(event Event) {
+		lock.Lock()
+		events = append(events, event)
+		lock.Unlock()
+	}
+}
+
+func TestMap_Watch(t *testing.T) {
+	var m Map
+	events, fn := recordEvents()
+	cancel := m.Watch(fn)
+
+	m.Store("a", 1)
+	m.LoadOrStore("a", 2)
+	m.LoadOrStore("b", 2)
+	m.ComputeIfAbsent("c", func(key interface{}) interface{} {
+		return 3
+	})
+	m.ComputeIfPresent("c", func(key, value interface{}) interface{} {
+		return value.(int) + 1
+	})
+	m.ComputeIfPresent("missing", func(key, value interface{}) interface{} {
+		return 0
+	})
+	m.Compute("d", func(oldValue interface{}, exists bool) (interface{}, bool) {
+		return 5, true
+	})
+	m.Compute("d", func(oldValue interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+	m.Compute("missing", func(oldValue interface{}, exists bool) (interface{}, bool) {
+		return nil, false
+	})
+	m.Swap("a", 6)
+	m.CompareAndSwap("a", 6, 7)
+	m.CompareAndSwap("a", 6, 8)
+	m.CompareAndDelete("b", 2)
+	m.Delete("a")
+	m.Delete("missing")
+	m.Clear()
+
+	assert.Equal(t, []Event{
+		{Type: EventStore, Key: "a", Value: 1},
+		{Type: EventStore, Key: "b", Value: 2},
+		{Type: EventStore, Key: "c", Value: 3},
+		{Type: EventStore, Key: "c", Value: 4},
+		{Type: EventStore, Key: "d", Value: 5},
+		{Type: EventDelete, Key: "d", Value: 5},
+		{Type: EventStore, Key: "a", Value: 6},
+		{Type: EventStore, Key: "a", Value: 7},
+		{Type: EventDelete, Key: "b", Value: 2},
+		{Type: EventDelete, Key: "a", Value: 7},
+		{Type: EventClear},
+	}, *events)
+
+	cancel()
+	cancel()
+	m.Store("a", 1)
+	assert.Len(t, *events, 11)
+}
+
+func TestMap_WatchPrefix(t *testing.T) {
+	var m Map
+	events, fn := recordEvents()
+	all, allFn := recordEvents()
+	defer m.Watch(fn, WithKeyPrefix("config/"))()
+	defer m.Watch(allFn)()
+
+	m.Store("config/a", 1)
+	m.Store("cache/a", 2)
+	m.Store(1, 3)
+	m.Clear()
+
+	assert.Equal(t, []Event{
+		{Type: EventStore, Key: "config/a", Value: 1},
+		{Type: EventClear},
+	}, *events)
+	assert.Len(t, *all, 4)
+}
+
+func TestMap_WatchChan(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	var m Map
+	events, cancel := m.WatchChan(1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Store("a", 1)
+		m.Store("b", 2)
+		m.Delete("a")
+	}()
+
+	assert.Equal(t, Event{Type: EventStore, Key: "a", Value: 1}, <-events)
+	assert.Equal(t, Event{Type: EventStore, Key: "b", Value: 2}, <-events)
+	assert.Equal(t, Event{Type: EventDelete, Key: "a", Value: 1}, <-events)
+	<-done
+
+	// A mutation blocked on a full channel is released by cancel.
+	m.Store("c", 3)
+	blocked := make(chan struct{})
+	go func() {
+		defer close(blocked)
+		m.Store("d", 4)
+	}()
+	cancel()
+	<-blocked
+
+	var received []Event
+	for event := range events {
+		received = append(received, event)
+	}
+	assert.Contains(t, received, Event{Type: EventStore, Key: "c", Value: 3})
+	cancel()
+}
+
+func TestSharedMap_Watch(t *testing.T) {
+	sharedMap := NewSharedMap(WithShardBlockSize(4))
+	events, fn := recordEvents()
+	defer sharedMap.Watch(fn, WithKeyPrefix("k"))()
+
+	sharedMap.Store("k1", 1)
+	sharedMap.Store("x1", 1)
+	sharedMap.LoadOrStore("k1", 2)
+	sharedMap.ComputeIfAbsent("k2", func(key string) interface{} {
+		return 2
+	})
+	sharedMap.ComputeIfPresent("k2", func(key string, value interface{}) interface{} {
+		return 3
+	})
+	sharedMap.Resize(16)
+	sharedMap.Delete("k2")
+	sharedMap.Delete("k2")
+	assert.Equal(t, []Event{
+		{Type: EventStore, Key: "k1", Value: 1},
+		{Type: EventStore, Key: "k2", Value: 2},
+		{Type: EventStore, Key: "k2", Value: 3},
+		{Type: EventDelete, Key: "k2", Value: 3},
+	}, *events)
+
+	*events = nil
+	sharedMap.MStore(map[string]interface{}{"k3": 3})
+	sharedMap.MDelete([]string{"k3", "missing"})
+	sharedMap.Store("k4", 4)
+	sharedMap.DeleteIf(func(key string, value interface{}) bool {
+		return key == "k4"
+	})
+	sharedMap.Clear()
+	assert.Equal(t, []Event{
+		{Type: EventStore, Key: "k3", Value: 3},
+		{Type: EventDelete, Key: "k3", Value: 3},
+		{Type: EventStore, Key: "k4", Value: 4},
+		{Type: EventDelete, Key: "k4", Value: 4},
+		{Type: EventClear},
+	}, *events)
+}
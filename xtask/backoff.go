@@ -0,0 +1,83 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtask
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff returns the delay before the given retry attempt, starting at 1.
+// last is the delay returned for the previous attempt, 0 before the first retry.
+type Backoff func(attempt int, last time.Duration) time.Duration
+
+// ConstantBackoff returns a Backoff waiting delay before every retry.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(attempt int, last time.Duration) time.Duration {
+		return delay
+	}
+}
+
+// LinearBackoff returns a Backoff waiting initial before the first retry,
+// and increment more before every following retry.
+func LinearBackoff(initial, increment time.Duration) Backoff {
+	return func(attempt int, last time.Duration) time.Duration {
+		return initial + increment*time.Duration(attempt-1)
+	}
+}
+
+// ExponentialBackoff returns a Backoff waiting initial before the first retry,
+// and doubling the delay before every following retry up to max.
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return func(attempt int, last time.Duration) time.Duration {
+		if last <= 0 {
+			return minDuration(initial, max)
+		}
+		if last > max/2 {
+			return max
+		}
+		return last * 2
+	}
+}
+
+// DecorrelatedJitterBackoff returns a Backoff waiting a random delay between base
+// and three times the previous delay, up to max.
+// The randomness spreads the retries of concurrent callers.
+func DecorrelatedJitterBackoff(base, max time.Duration) Backoff {
+	return func(attempt int, last time.Duration) time.Duration {
+		if last < base {
+			last = base
+		}
+
+		upper := last * 3
+		if upper <= base || upper > max {
+			// Overflowed or capped.
+			upper = max
+		}
+		if upper <= base {
+			return upper
+		}
+		return base + time.Duration(rand.Int63n(int64(upper-base)+1))
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
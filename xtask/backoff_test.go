@@ -0,0 +1,49 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtask
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	delays := func(backoff Backoff, n int) []time.Duration {
+		var (
+			last   time.Duration
+			result []time.Duration
+		)
+		for i := 1; i <= n; i++ {
+			last = backoff(i, last)
+			result = append(result, last)
+		}
+		return result
+	}
+
+	assert.Equal(t, []time.Duration{2, 2, 2}, delays(ConstantBackoff(2), 3))
+	assert.Equal(t, []time.Duration{2, 5, 8}, delays(LinearBackoff(2, 3), 3))
+	assert.Equal(t, []time.Duration{2, 4, 8, 10, 10}, delays(ExponentialBackoff(2, 10), 5))
+	assert.Equal(t, []time.Duration{10, 10}, delays(ExponentialBackoff(20, 10), 2))
+
+	for _, delay := range delays(DecorrelatedJitterBackoff(time.Millisecond, time.Second), 100) {
+		assert.GreaterOrEqual(t, delay, time.Millisecond)
+		assert.LessOrEqual(t, delay, time.Second)
+	}
+	assert.Equal(t, []time.Duration{5, 5}, delays(DecorrelatedJitterBackoff(10, 5), 2))
+}
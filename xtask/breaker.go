@@ -0,0 +1,295 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtask
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// StateClosed lets all calls through and counts their failures.
+	StateClosed BreakerState = iota
+	// StateOpen rejects all calls until the cooldown elapsed.
+	StateOpen
+	// StateHalfOpen lets a limited number of probe calls through to decide whether to close again.
+	StateHalfOpen
+)
+
+const (
+	outcomeFailure outcome = iota
+	outcomeSuccess
+	// outcomeCanceled is a call canceled by its caller, it's neither a success nor a failure.
+	outcomeCanceled
+)
+
+// ErrBreakerOpen is an error that indicates a call was rejected by an open Breaker.
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+type (
+	// BreakerState is the state of a Breaker.
+	BreakerState int
+
+	// outcome is the outcome of a call let through by a Breaker.
+	outcome int
+
+	// BreakerOption defines the method to customize a Breaker.
+	BreakerOption func(*breakerOptions)
+
+	breakerOptions struct {
+		window         time.Duration
+		buckets        int
+		failureRate    float64
+		minRequests    int
+		cooldown       time.Duration
+		halfOpenProbes int
+		onStateChange  func(from, to BreakerState)
+	}
+
+	// Breaker is a circuit breaker. It opens once the failure rate of the calls
+	// over a rolling window reaches a threshold, rejects the calls while open,
+	// and lets a few probe calls through after a cooldown to decide whether to close again.
+	Breaker struct {
+		options *breakerOptions
+		lock    sync.Mutex
+		state   BreakerState
+		window  *rollingWindow
+		// openedAt is the time the breaker opened, guarded by lock.
+		openedAt time.Time
+		// probes is the number of probe calls in flight, successes the number of succeeded ones.
+		probes    int
+		successes int
+	}
+)
+
+// WithBreakerWindow customizes a Breaker to count the calls over window, split into buckets.
+// Defaults to 10 seconds in 10 buckets.
+func WithBreakerWindow(window time.Duration, buckets int) BreakerOption {
+	return func(options *breakerOptions) {
+		options.window = window
+		options.buckets = buckets
+	}
+}
+
+// WithFailureRate customizes a Breaker to open once the failure rate reaches failureRate,
+// counted over at least minRequests calls. Defaults to 0.5 over 20 calls.
+func WithFailureRate(failureRate float64, minRequests int) BreakerOption {
+	return func(options *breakerOptions) {
+		options.failureRate = failureRate
+		options.minRequests = minRequests
+	}
+}
+
+// WithCooldown customizes how long a Breaker stays open before probing. Defaults to 5 seconds.
+func WithCooldown(cooldown time.Duration) BreakerOption {
+	return func(options *breakerOptions) {
+		options.cooldown = cooldown
+	}
+}
+
+// WithHalfOpenProbes customizes the number of probe calls a half-open Breaker lets through,
+// the Breaker closes once they all succeed. Defaults to 1.
+func WithHalfOpenProbes(probes int) BreakerOption {
+	return func(options *breakerOptions) {
+		options.halfOpenProbes = probes
+	}
+}
+
+// WithOnStateChange customizes a Breaker to call onStateChange on every state change.
+func WithOnStateChange(onStateChange func(from, to BreakerState)) BreakerOption {
+	return func(options *breakerOptions) {
+		options.onStateChange = onStateChange
+	}
+}
+
+// NewBreaker returns a closed Breaker.
+func NewBreaker(opts ...BreakerOption) *Breaker {
+	options := &breakerOptions{
+		window:         time.Second * 10,
+		buckets:        10,
+		failureRate:    0.5,
+		minRequests:    20,
+		cooldown:       time.Second * 5,
+		halfOpenProbes: 1,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.buckets < 1 {
+		panic("buckets should be greater than 0")
+	}
+	if options.window < time.Duration(options.buckets) {
+		panic("window should be greater than buckets")
+	}
+	if options.halfOpenProbes < 1 {
+		panic("probes should be greater than 0")
+	}
+
+	return &Breaker{
+		options: options,
+		window:  newRollingWindow(options.buckets, options.window/time.Duration(options.buckets), time.Now()),
+	}
+}
+
+// State returns the current state of b.
+func (b *Breaker) State() BreakerState {
+	b.lock.Lock()
+	state, changed := b.stateLocked(time.Now())
+	b.lock.Unlock()
+
+	b.notify(changed)
+	return state
+}
+
+// Do runs fn if b lets the call through, otherwise it returns ErrBreakerOpen.
+// An error returned by fn counts as a failure, a deadline exceeded included,
+// but a call canceled by ctx doesn't count.
+// A panic of fn counts as a failure and is propagated.
+func (b *Breaker) Do(ctx context.Context, fn func() error) (err error) {
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	probe, err := b.allow()
+	if err != nil {
+		return err
+	}
+
+	result := outcomeFailure
+	defer func() {
+		b.done(probe, result)
+	}()
+
+	err = fn()
+	if err == nil {
+		result = outcomeSuccess
+	} else if ctx.Err() == context.Canceled && errors.Is(err, context.Canceled) {
+		result = outcomeCanceled
+	}
+	return err
+}
+
+func (b *Breaker) allow() (probe bool, err error) {
+	b.lock.Lock()
+	state, changed := b.stateLocked(time.Now())
+	probe, err = b.allowLocked(state)
+	b.lock.Unlock()
+
+	b.notify(changed)
+	return probe, err
+}
+
+func (b *Breaker) allowLocked(state BreakerState) (probe bool, err error) {
+	switch state {
+	case StateOpen:
+		return false, ErrBreakerOpen
+	case StateHalfOpen:
+		if b.probes+b.successes >= b.options.halfOpenProbes {
+			return false, ErrBreakerOpen
+		}
+		b.probes++
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (b *Breaker) done(probe bool, result outcome) {
+	b.lock.Lock()
+	changed := b.doneLocked(probe, result, time.Now())
+	b.lock.Unlock()
+
+	b.notify(changed)
+}
+
+func (b *Breaker) doneLocked(probe bool, result outcome, now time.Time) []BreakerState {
+	if probe {
+		b.probes--
+		if b.state != StateHalfOpen || result == outcomeCanceled {
+			// Another probe already decided, or the slot is freed for the next probe.
+			return nil
+		}
+		if result == outcomeFailure {
+			return b.setStateLocked(StateOpen, now)
+		}
+		b.successes++
+		if b.successes >= b.options.halfOpenProbes {
+			return b.setStateLocked(StateClosed, now)
+		}
+		return nil
+	}
+
+	if b.state != StateClosed || result == outcomeCanceled {
+		// The call was let through before the breaker opened, or doesn't count.
+		return nil
+	}
+	b.window.add(now, result == outcomeSuccess)
+	if result == outcomeSuccess {
+		return nil
+	}
+	successes, failures := b.window.counts(now)
+	total := successes + failures
+	if total >= b.options.minRequests && float64(failures)/float64(total) >= b.options.failureRate {
+		return b.setStateLocked(StateOpen, now)
+	}
+	return nil
+}
+
+// stateLocked returns the current state, moving an open breaker to half-open after the cooldown.
+func (b *Breaker) stateLocked(now time.Time) (BreakerState, []BreakerState) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.options.cooldown {
+		return StateHalfOpen, b.setStateLocked(StateHalfOpen, now)
+	}
+	return b.state, nil
+}
+
+// setStateLocked changes the state and returns the previous and the new states for notify.
+func (b *Breaker) setStateLocked(state BreakerState, now time.Time) []BreakerState {
+	from := b.state
+	b.state = state
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateHalfOpen:
+		b.successes = 0
+	case StateClosed:
+		b.window.reset(now)
+	}
+	return []BreakerState{from, state}
+}
+
+// notify calls the state change callback, outside of the lock.
+func (b *Breaker) notify(changed []BreakerState) {
+	if changed != nil && b.options.onStateChange != nil {
+		b.options.onStateChange(changed[0], changed[1])
+	}
+}
+
+// String returns the name of s.
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
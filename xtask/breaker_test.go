@@ -0,0 +1,159 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtask
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	var (
+		lock        sync.Mutex
+		transitions []string
+	)
+	breaker := NewBreaker(
+		WithFailureRate(0.5, 4),
+		WithCooldown(time.Millisecond*20),
+		WithHalfOpenProbes(2),
+		WithOnStateChange(func(from, to BreakerState) {
+			lock.Lock()
+			transitions = append(transitions, from.String()+"->"+to.String())
+			lock.Unlock()
+		}),
+	)
+	errFail := errors.New("fail")
+	fail := func() error {
+		return errFail
+	}
+	succeed := func() error {
+		return nil
+	}
+
+	ctx := context.Background()
+	assert.NoError(t, breaker.Do(ctx, succeed))
+	assert.NoError(t, breaker.Do(ctx, succeed))
+	assert.Equal(t, errFail, breaker.Do(ctx, fail))
+	assert.Equal(t, StateClosed, breaker.State())
+	assert.Equal(t, errFail, breaker.Do(ctx, fail))
+	assert.Equal(t, StateOpen, breaker.State())
+	assert.Equal(t, ErrBreakerOpen, breaker.Do(ctx, succeed))
+
+	// A failed probe opens the breaker again.
+	time.Sleep(time.Millisecond * 25)
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	assert.Equal(t, errFail, breaker.Do(ctx, fail))
+	assert.Equal(t, StateOpen, breaker.State())
+
+	time.Sleep(time.Millisecond * 25)
+	assert.NoError(t, breaker.Do(ctx, succeed))
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	assert.NoError(t, breaker.Do(ctx, succeed))
+	assert.Equal(t, StateClosed, breaker.State())
+
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, transitions)
+}
+
+func TestBreaker_HalfOpenProbes(t *testing.T) {
+	breaker := NewBreaker(WithFailureRate(1, 1), WithCooldown(time.Millisecond))
+	ctx := context.Background()
+	assert.Error(t, breaker.Do(ctx, func() error {
+		return errors.New("")
+	}))
+	time.Sleep(time.Millisecond * 5)
+
+	probing := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		_ = breaker.Do(ctx, func() error {
+			close(probing)
+			<-release
+			return nil
+		})
+	}()
+	<-probing
+	assert.Equal(t, ErrBreakerOpen, breaker.Do(ctx, func() error {
+		return nil
+	}))
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		return breaker.State() == StateClosed
+	}, time.Second, time.Millisecond)
+}
+
+func TestBreaker_Context(t *testing.T) {
+	breaker := NewBreaker(WithFailureRate(0.5, 1), WithCooldown(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Equal(t, context.Canceled, breaker.Do(ctx, func() error {
+		cancel()
+		return ctx.Err()
+	}))
+	// The canceled call doesn't count.
+	assert.Equal(t, StateClosed, breaker.State())
+	assert.Equal(t, context.Canceled, breaker.Do(ctx, func() error {
+		return nil
+	}))
+
+	// A call timing out counts as a failure.
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer timeoutCancel()
+	assert.Equal(t, context.DeadlineExceeded, breaker.Do(timeoutCtx, func() error {
+		<-timeoutCtx.Done()
+		return timeoutCtx.Err()
+	}))
+	assert.Equal(t, StateOpen, breaker.State())
+
+	// A canceled probe frees its slot for the next probe.
+	time.Sleep(time.Millisecond * 5)
+	probeCtx, probeCancel := context.WithCancel(context.Background())
+	assert.Equal(t, context.Canceled, breaker.Do(probeCtx, func() error {
+		probeCancel()
+		return probeCtx.Err()
+	}))
+	assert.Equal(t, StateHalfOpen, breaker.State())
+	assert.NoError(t, breaker.Do(context.Background(), func() error {
+		return nil
+	}))
+	assert.Equal(t, StateClosed, breaker.State())
+
+	assert.Panics(t, func() {
+		_ = breaker.Do(context.Background(), func() error {
+			panic("")
+		})
+	})
+	assert.Equal(t, StateOpen, breaker.State())
+
+	assert.Panics(t, func() {
+		NewBreaker(WithBreakerWindow(time.Second, 0))
+	})
+	assert.Panics(t, func() {
+		NewBreaker(WithHalfOpenProbes(0))
+	})
+	assert.Equal(t, "unknown", BreakerState(-1).String())
+}
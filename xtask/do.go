@@ -18,35 +18,112 @@ package xtask
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"runtime/debug"
-	"strings"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	taskRunning int32 = iota
+	taskFinished
+	taskAbandoned
+)
+
+var (
+	// ErrTooManyAbandoned is an error that indicates a task wasn't started
+	// because too many abandoned tasks are still running, see SetMaxAbandoned.
+	ErrTooManyAbandoned = errors.New("too many abandoned tasks")
+
+	// abandonedTasks counts the tasks abandoned by Do.
+	abandonedTasks abandonedCounter
+)
+
+// abandonedCounter counts the abandoned tasks still running.
+type abandonedCounter struct {
+	// max is the maximum number of abandoned tasks, 0 means unlimited.
+	max int64
+	// running is the number of abandoned tasks still running.
+	running int64
+}
+
+// SetMaxAbandoned limits the number of tasks abandoned by Do because the context was done first
+// which may be running at once. Once the limit is reached, Do returns ErrTooManyAbandoned
+// without starting the task. n <= 0 means unlimited, which is the default.
+//
+// A task is only counted once abandoned, so the tasks started concurrently while the limit
+// wasn't reached yet may exceed it once they are abandoned too.
+func SetMaxAbandoned(n int) {
+	atomic.StoreInt64(&abandonedTasks.max, int64(n))
+}
+
+// Abandoned returns the number of tasks abandoned by Do which are still running.
+func Abandoned() int {
+	return int(atomic.LoadInt64(&abandonedTasks.running))
+}
+
+// full reports whether no more task can be started.
+func (c *abandonedCounter) full() bool {
+	max := atomic.LoadInt64(&c.max)
+	return max > 0 && atomic.LoadInt64(&c.running) >= max
+}
+
 // Do fn with ctx control.
-func Do(ctx context.Context, do func() error, deferFunc func()) (err error) {
+//
+// By default a panic of fn is propagated to the caller as a string holding the panic value and the stack,
+// see WithPanicAsError and WithPanicHook to customize it.
+func Do(ctx context.Context, do func() error, deferFunc func(), opts ...DoOption) (err error) {
 	if deferFunc != nil {
 		defer deferFunc()
 	}
 
+	return DoCtx(ctx, func(context.Context) error {
+		return do()
+	}, opts...)
+}
+
+// DoCtx fn with ctx control, ctx is passed to fn so that it can stop
+// once DoCtx returned because ctx is done.
+func DoCtx(ctx context.Context, do func(ctx context.Context) error, opts ...DoOption) error {
+	return doCtx(ctx, &abandonedTasks, do, opts...)
+}
+
+func doCtx(ctx context.Context, abandoned *abandonedCounter, do func(ctx context.Context) error,
+	opts ...DoOption) (err error) {
+	options := newDoOptions(opts)
+
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 	}
 
+	if abandoned.full() {
+		return ErrTooManyAbandoned
+	}
+
 	doneChan := make(chan error, 1)
 	panicChan := make(chan interface{}, 1)
+	state := taskRunning
 
 	go func() {
+		defer func() {
+			if !atomic.CompareAndSwapInt32(&state, taskRunning, taskFinished) {
+				atomic.AddInt64(&abandoned.running, -1)
+			}
+		}()
 		defer func() {
 			if r := recover(); r != nil {
-				panicChan <- fmt.Sprintf("%+v\n\n%s", r, strings.TrimSpace(string(debug.Stack())))
+				panicErr := options.recovered(r)
+				if options.panicAsError {
+					doneChan <- panicErr
+				} else {
+					panicChan <- fmt.Sprintf("%+v\n\n%s", panicErr.Value, panicErr.Stack)
+				}
 			}
 		}()
 
-		doneChan <- do()
+		doneChan <- do(ctx)
 	}()
 
 	select {
@@ -56,19 +133,22 @@ func Do(ctx context.Context, do func() error, deferFunc func()) (err error) {
 		return
 	case <-ctx.Done():
 		err = ctx.Err()
+		if atomic.CompareAndSwapInt32(&state, taskRunning, taskAbandoned) {
+			atomic.AddInt64(&abandoned.running, 1)
+		}
 	}
 	return
 
 }
 
 // DoWithTimeout fn with timeout control.
-func DoWithTimeout(timeout time.Duration, do func() error, deferFunc func()) error {
+func DoWithTimeout(timeout time.Duration, do func() error, deferFunc func(), opts ...DoOption) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	return Do(ctx, do, deferFunc)
+	return Do(ctx, do, deferFunc, opts...)
 }
 
 // DoWithoutDefer fn with ctx control, but not defer.
-func DoWithoutDefer(ctx context.Context, do func() error) error {
-	return Do(ctx, do, nil)
+func DoWithoutDefer(ctx context.Context, do func() error, opts ...DoOption) error {
+	return Do(ctx, do, nil, opts...)
 }
@@ -20,6 +20,10 @@ import (
 	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+	"io"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -106,3 +110,76 @@ func TestDoWithOutDefer(t *testing.T) {
 		})
 	})
 }
+
+func TestDoCtx(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	stopped := make(chan struct{})
+	err := DoCtx(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	<-stopped
+
+	assert.Equal(t, io.EOF, DoCtx(context.Background(), func(ctx context.Context) error {
+		return io.EOF
+	}))
+}
+
+func TestSetMaxAbandoned(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	SetMaxAbandoned(2)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&abandonedTasks.max))
+	SetMaxAbandoned(0)
+
+	// The tasks which aren't abandoned aren't limited.
+	abandoned := &abandonedCounter{max: 1}
+	var (
+		wait    sync.WaitGroup
+		started sync.WaitGroup
+	)
+	block := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		wait.Add(1)
+		started.Add(1)
+		go func() {
+			defer wait.Done()
+			assert.NoError(t, doCtx(context.Background(), abandoned, func(context.Context) error {
+				started.Done()
+				<-block
+				return nil
+			}))
+		}()
+	}
+	started.Wait()
+	close(block)
+	wait.Wait()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	assert.Equal(t, context.Canceled, doCtx(ctx, abandoned, func(context.Context) error {
+		cancel()
+		<-release
+		return nil
+	}))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&abandoned.running))
+
+	var startedAbandoned bool
+	assert.Equal(t, ErrTooManyAbandoned, doCtx(context.Background(), abandoned, func(context.Context) error {
+		startedAbandoned = true
+		return nil
+	}))
+	assert.False(t, startedAbandoned)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&abandoned.running) == 0
+	}, time.Second, time.Millisecond)
+	assert.NoError(t, doCtx(context.Background(), abandoned, func(context.Context) error {
+		return nil
+	}))
+}
@@ -0,0 +1,125 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtask
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+var (
+	panicHookLock sync.RWMutex
+	panicHook     func(panicErr *PanicError)
+)
+
+type (
+	// PanicError is an error that holds a panic recovered from a task.
+	PanicError struct {
+		// Task is the name of the task given by WithTaskName.
+		Task string
+		// Value is the value passed to panic.
+		Value interface{}
+		// Stack is the stack of the goroutine of the task when it panicked.
+		Stack string
+	}
+
+	// DoOption defines the method to customize Do.
+	DoOption func(*doOptions)
+
+	doOptions struct {
+		name         string
+		panicAsError bool
+		panicHook    func(panicErr *PanicError)
+	}
+)
+
+// WithTaskName customizes a Do call with a task name, reported in PanicError.
+func WithTaskName(name string) DoOption {
+	return func(options *doOptions) {
+		options.name = name
+	}
+}
+
+// WithPanicAsError customizes a Do call to return a panic of the task as a *PanicError
+// instead of propagating the panic to the caller.
+func WithPanicAsError() DoOption {
+	return func(options *doOptions) {
+		options.panicAsError = true
+	}
+}
+
+// WithPanicHook customizes a Do call to call hook with every panic of the task, after the global hook.
+// The hook is called on the goroutine of the task, even if the task was abandoned.
+func WithPanicHook(hook func(panicErr *PanicError)) DoOption {
+	return func(options *doOptions) {
+		options.panicHook = hook
+	}
+}
+
+// SetPanicHook sets a hook called with every panic of the tasks run by Do, e.g. to report them.
+// The hook is called on the goroutine of the task, even if the task was abandoned.
+// A nil hook removes the hook.
+func SetPanicHook(hook func(panicErr *PanicError)) {
+	panicHookLock.Lock()
+	panicHook = hook
+	panicHookLock.Unlock()
+}
+
+// Error returns a string that represents the panic.
+func (e *PanicError) Error() string {
+	if e.Task == "" {
+		return fmt.Sprintf("panic: %v", e.Value)
+	}
+	return fmt.Sprintf("panic in task %s: %v", e.Task, e.Value)
+}
+
+// Unwrap returns the value passed to panic if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+func newDoOptions(opts []DoOption) *doOptions {
+	options := &doOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// recovered returns a PanicError for the value r recovered from the task, and calls the hooks.
+// It must be called from the deferred function which recovered r, to capture the stack of the panic.
+func (o *doOptions) recovered(r interface{}) *PanicError {
+	panicErr := &PanicError{
+		Task:  o.name,
+		Value: r,
+		Stack: strings.TrimSpace(string(debug.Stack())),
+	}
+
+	panicHookLock.RLock()
+	hook := panicHook
+	panicHookLock.RUnlock()
+	if hook != nil {
+		hook(panicErr)
+	}
+	if o.panicHook != nil {
+		o.panicHook(panicErr)
+	}
+	return panicErr
+}
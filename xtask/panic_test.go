@@ -0,0 +1,78 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtask
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPanicAsError(t *testing.T) {
+	err := Do(context.Background(), func() error {
+		panic(io.EOF)
+	}, nil, WithPanicAsError(), WithTaskName("read"))
+
+	var panicErr *PanicError
+	assert.True(t, errors.As(err, &panicErr))
+	assert.Equal(t, "read", panicErr.Task)
+	assert.Equal(t, io.EOF, panicErr.Value)
+	assert.Contains(t, panicErr.Stack, "panic_test.go")
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "panic in task read: EOF", err.Error())
+
+	err = DoWithoutDefer(context.Background(), func() error {
+		panic(1)
+	}, WithPanicAsError())
+	assert.Equal(t, "panic: 1", err.Error())
+	assert.Nil(t, errors.Unwrap(err))
+}
+
+func TestPanicHook(t *testing.T) {
+	reported := make(chan *PanicError, 2)
+	SetPanicHook(func(panicErr *PanicError) {
+		reported <- panicErr
+	})
+	defer SetPanicHook(nil)
+
+	var local *PanicError
+	assert.Panics(t, func() {
+		_ = DoWithTimeout(time.Second, func() error {
+			panic("boom")
+		}, nil, WithTaskName("boom"), WithPanicHook(func(panicErr *PanicError) {
+			// The global hook is called first.
+			assert.Len(t, reported, 1)
+			local = panicErr
+		}))
+	})
+	global := <-reported
+	assert.Equal(t, "boom", global.Value)
+	assert.Same(t, global, local)
+
+	// The panic of an abandoned task is still reported.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, DoCtx(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		panic("late")
+	}))
+	assert.Equal(t, "late", (<-reported).Value)
+}
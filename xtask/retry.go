@@ -16,7 +16,14 @@
 
 package xtask
 
-import "github.com/chenquan/go-pkg/xerror"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/chenquan/go-pkg/xerror"
+)
 
 const defaultRetryTimes = 3
 
@@ -25,7 +32,20 @@ type (
 	RetryOption func(*retryOptions)
 
 	retryOptions struct {
-		times int // number of retries.
+		times          int // number of retries.
+		backoff        Backoff
+		maxElapsedTime time.Duration
+		attemptTimeout time.Duration
+		retryIf        func(err error) bool
+		onRetry        func(attempt int, err error, nextDelay time.Duration)
+	}
+
+	permanentError struct {
+		err error
+	}
+
+	retryAfterError struct {
+		delay time.Duration
 	}
 )
 
@@ -36,23 +56,135 @@ func WithRetry(times int) RetryOption {
 	}
 }
 
+// WithBackoff customize a DoWithRetry call with given backoff between the attempts.
+// Defaults to retry immediately.
+func WithBackoff(backoff Backoff) RetryOption {
+	return func(options *retryOptions) {
+		options.backoff = backoff
+	}
+}
+
+// WithMaxElapsedTime customize a DoWithRetry call to give up once the next attempt
+// would start after maxElapsedTime since the first attempt.
+func WithMaxElapsedTime(maxElapsedTime time.Duration) RetryOption {
+	return func(options *retryOptions) {
+		options.maxElapsedTime = maxElapsedTime
+	}
+}
+
+// WithAttemptTimeout customize a DoWithRetry call to fail every attempt running longer than timeout.
+// The attempt isn't interrupted, only abandoned, see Do.
+func WithAttemptTimeout(timeout time.Duration) RetryOption {
+	return func(options *retryOptions) {
+		options.attemptTimeout = timeout
+	}
+}
+
+// WithRetryIf customize a DoWithRetry call to retry only the errors for which retryIf returns true.
+func WithRetryIf(retryIf func(err error) bool) RetryOption {
+	return func(options *retryOptions) {
+		options.retryIf = retryIf
+	}
+}
+
+// WithOnRetry customize a DoWithRetry call to call onRetry before waiting for every retry,
+// with the number of the retry, starting at 1, the error of the failed attempt and the delay before the retry.
+func WithOnRetry(onRetry func(attempt int, err error, nextDelay time.Duration)) RetryOption {
+	return func(options *retryOptions) {
+		options.onRetry = onRetry
+	}
+}
+
+// Permanent wraps err to stop DoWithRetry from retrying, DoWithRetry returns err unwrapped.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// RetryAfter returns an error retried by DoWithRetry after delay instead of the delay of the backoff.
+// It may be wrapped to keep the cause of the failure, e.g. with fmt.Errorf("%v: %w", cause, RetryAfter(delay)).
+func RetryAfter(delay time.Duration) error {
+	return &retryAfterError{delay: delay}
+}
+
 // DoWithRetry runs fn, and retries if failed. Default to retry 3 times.
 func DoWithRetry(fn func() error, opts ...RetryOption) error {
+	return DoWithRetryCtx(context.Background(), fn, opts...)
+}
+
+// DoWithRetryCtx runs fn, and retries if failed, waiting between the attempts as given by WithBackoff.
+// Default to retry 3 times.
+// DoWithRetryCtx gives up once ctx is done, the returned error contains the errors of all attempts.
+func DoWithRetryCtx(ctx context.Context, fn func() error, opts ...RetryOption) error {
 	options := newRetryOptions()
 	for _, opt := range opts {
 		opt(options)
 	}
 
-	var batchError xerror.BatchError
-	for i := 0; i <= options.times; i++ {
-		if err := fn(); err != nil {
-			batchError.Add(err)
-		} else {
+	var (
+		batchError xerror.BatchError
+		delay      time.Duration
+		timer      *time.Timer
+	)
+	start := time.Now()
+	for i := 0; ; i++ {
+		err := options.attempt(ctx, fn)
+		if err == nil {
 			return nil
 		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			batchError.Add(permanent.err)
+			return batchError.Err()
+		}
+		batchError.Add(err)
+		if i == options.times || ctx.Err() != nil ||
+			(options.retryIf != nil && !options.retryIf(err)) {
+			return batchError.Err()
+		}
+
+		var retryAfter *retryAfterError
+		if errors.As(err, &retryAfter) {
+			delay = retryAfter.delay
+		} else if options.backoff != nil {
+			delay = options.backoff(i+1, delay)
+		}
+		if options.maxElapsedTime > 0 && time.Since(start)+delay > options.maxElapsedTime {
+			return batchError.Err()
+		}
+		if options.onRetry != nil {
+			options.onRetry(i+1, err, delay)
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(delay)
+			defer timer.Stop()
+		} else {
+			timer.Reset(delay)
+		}
+		select {
+		case <-ctx.Done():
+			batchError.Add(ctx.Err())
+			return batchError.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (o *retryOptions) attempt(ctx context.Context, fn func() error) error {
+	if o.attemptTimeout <= 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn()
 	}
 
-	return batchError.Err()
+	attemptCtx, cancel := context.WithTimeout(ctx, o.attemptTimeout)
+	defer cancel()
+	return DoWithoutDefer(attemptCtx, fn)
 }
 
 func newRetryOptions() *retryOptions {
@@ -60,3 +192,15 @@ func newRetryOptions() *retryOptions {
 		times: defaultRetryTimes,
 	}
 }
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("retry after %s", e.delay)
+}
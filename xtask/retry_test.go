@@ -17,9 +17,14 @@
 package xtask
 
 import (
+	"context"
 	"errors"
-	"github.com/stretchr/testify/assert"
+	"fmt"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestRetry(t *testing.T) {
@@ -55,3 +60,109 @@ func TestRetry(t *testing.T) {
 		return errors.New("")
 	}, WithRetry(total)))
 }
+
+func TestDoWithRetryCtx(t *testing.T) {
+	t.Run("backoff", func(t *testing.T) {
+		var times int
+		start := time.Now()
+		assert.NoError(t, DoWithRetryCtx(context.Background(), func() error {
+			times++
+			if times == 3 {
+				return nil
+			}
+			return errors.New("")
+		}, WithBackoff(ConstantBackoff(time.Millisecond*20))))
+		assert.Equal(t, 3, times)
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*40)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		var times int
+		err := DoWithRetryCtx(ctx, func() error {
+			times++
+			return errors.New("")
+		}, WithBackoff(ConstantBackoff(time.Minute)))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, times)
+	})
+
+	t.Run("max elapsed time", func(t *testing.T) {
+		var times int
+		err := DoWithRetryCtx(context.Background(), func() error {
+			times++
+			return errors.New("")
+		}, WithRetry(100), WithBackoff(ConstantBackoff(time.Millisecond*10)),
+			WithMaxElapsedTime(time.Millisecond*100))
+		assert.Error(t, err)
+		// At most one attempt every 10ms fits in 100ms, far fewer than the 101 allowed.
+		assert.GreaterOrEqual(t, times, 2)
+		assert.LessOrEqual(t, times, 10)
+	})
+
+	t.Run("attempt timeout", func(t *testing.T) {
+		var times int32
+		err := DoWithRetryCtx(context.Background(), func() error {
+			if atomic.AddInt32(&times, 1) < 3 {
+				time.Sleep(time.Millisecond * 50)
+			}
+			return nil
+		}, WithAttemptTimeout(time.Millisecond*10))
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&times))
+	})
+}
+
+func TestDoWithRetryClassification(t *testing.T) {
+	errFatal := errors.New("fatal")
+
+	t.Run("retry if", func(t *testing.T) {
+		var times int
+		err := DoWithRetry(func() error {
+			times++
+			if times == 2 {
+				return errFatal
+			}
+			return errors.New("")
+		}, WithRetryIf(func(err error) bool {
+			return err != errFatal
+		}))
+		assert.ErrorIs(t, err, errFatal)
+		assert.Equal(t, 2, times)
+	})
+
+	t.Run("permanent", func(t *testing.T) {
+		var times int
+		err := DoWithRetry(func() error {
+			times++
+			return Permanent(errFatal)
+		})
+		assert.Equal(t, errFatal, err)
+		assert.Equal(t, 1, times)
+		assert.Nil(t, Permanent(nil))
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		var (
+			times  int
+			delays []time.Duration
+		)
+		err := DoWithRetry(func() error {
+			times++
+			if times == 1 {
+				return fmt.Errorf("throttled: %w", RetryAfter(time.Millisecond))
+			}
+			return errors.New("")
+		}, WithRetry(2), WithBackoff(ConstantBackoff(time.Millisecond*2)),
+			WithOnRetry(func(attempt int, err error, nextDelay time.Duration) {
+				assert.Equal(t, len(delays)+1, attempt)
+				assert.Error(t, err)
+				delays = append(delays, nextDelay)
+			}))
+		assert.Error(t, err)
+		assert.Equal(t, 3, times)
+		assert.Equal(t, []time.Duration{time.Millisecond, time.Millisecond * 2}, delays)
+		assert.Equal(t, "retry after 1ms", RetryAfter(time.Millisecond).Error())
+	})
+}
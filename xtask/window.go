@@ -0,0 +1,88 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtask
+
+import "time"
+
+type (
+	// rollingWindow counts the outcomes of the calls over the last buckets*bucketDuration.
+	// It isn't safe for concurrent use.
+	rollingWindow struct {
+		buckets        []windowBucket
+		bucketDuration time.Duration
+		// offset is the index of the current bucket, started at lastTime.
+		offset   int
+		lastTime time.Time
+	}
+
+	windowBucket struct {
+		successes int
+		failures  int
+	}
+)
+
+func newRollingWindow(buckets int, bucketDuration time.Duration, now time.Time) *rollingWindow {
+	return &rollingWindow{
+		buckets:        make([]windowBucket, buckets),
+		bucketDuration: bucketDuration,
+		lastTime:       now,
+	}
+}
+
+func (w *rollingWindow) add(now time.Time, success bool) {
+	w.advance(now)
+	if success {
+		w.buckets[w.offset].successes++
+	} else {
+		w.buckets[w.offset].failures++
+	}
+}
+
+func (w *rollingWindow) counts(now time.Time) (successes, failures int) {
+	w.advance(now)
+	for _, bucket := range w.buckets {
+		successes += bucket.successes
+		failures += bucket.failures
+	}
+	return
+}
+
+func (w *rollingWindow) reset(now time.Time) {
+	for i := range w.buckets {
+		w.buckets[i] = windowBucket{}
+	}
+	w.offset = 0
+	w.lastTime = now
+}
+
+// advance moves the current bucket to now, clearing the expired buckets.
+func (w *rollingWindow) advance(now time.Time) {
+	span := int(now.Sub(w.lastTime) / w.bucketDuration)
+	if span <= 0 {
+		return
+	}
+	if span >= len(w.buckets) {
+		w.reset(now)
+		return
+	}
+
+	for i := 0; i < span; i++ {
+		w.offset = (w.offset + 1) % len(w.buckets)
+		w.buckets[w.offset] = windowBucket{}
+	}
+	w.lastTime = w.lastTime.Add(time.Duration(span) * w.bucketDuration)
+}
@@ -0,0 +1,50 @@
+/*
+ *    Copyright 2021 chenquan
+ *
+ *    Licensed under the Apache License, Version 2.0 (the "License");
+ *    you may not use this file except in compliance with the License.
+ *    You may obtain a copy of the License at
+ *
+ *        http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *    Unless required by applicable law or agreed to in writing, software
+ *    distributed under the License is distributed on an "AS IS" BASIS,
+ *    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *    See the License for the specific language governing permissions and
+ *    limitations under the License.
+ */
+
+package xtask
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollingWindow(t *testing.T) {
+	now := time.Now()
+	window := newRollingWindow(3, time.Second, now)
+	window.add(now, true)
+	window.add(now.Add(time.Second), false)
+	window.add(now.Add(time.Second*2), false)
+
+	successes, failures := window.counts(now.Add(time.Second * 2))
+	assert.Equal(t, 1, successes)
+	assert.Equal(t, 2, failures)
+
+	// The first bucket expired.
+	successes, failures = window.counts(now.Add(time.Second * 3))
+	assert.Equal(t, 0, successes)
+	assert.Equal(t, 2, failures)
+
+	successes, failures = window.counts(now.Add(time.Second * 10))
+	assert.Equal(t, 0, successes)
+	assert.Equal(t, 0, failures)
+
+	window.add(now.Add(time.Second*10), true)
+	window.reset(now.Add(time.Second * 10))
+	successes, failures = window.counts(now.Add(time.Second * 10))
+	assert.Equal(t, 0, successes+failures)
+}